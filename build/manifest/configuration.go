@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// configurationGoFilePath is where the Configuration struct generated from the manifest's settings schema is written
+const configurationGoFilePath = "server/configuration_gen.go"
+
+const configurationGoFileHeader = `// This file is automatically generated from the settings_schema in plugin.json. Do not modify it manually;
+// change plugin.json and run make apply instead.
+
+package main
+
+`
+
+// settingGoTypes maps the manifest setting types stored in the configuration to the Go types of their fields.
+// Custom settings are rendered by the webapp and have no field.
+var settingGoTypes = map[string]string{
+	"bool":      "bool",
+	"number":    "int",
+	"text":      "string",
+	"longtext":  "string",
+	"dropdown":  "string",
+	"radio":     "string",
+	"username":  "string",
+	"generated": "string",
+}
+
+// generateConfiguration generates the Go source of the Configuration struct, the defaults of its settings and the
+// checks that configured values match the schema, from the manifest's settings schema
+func generateConfiguration(manifest *model.Manifest) ([]byte, error) {
+	var settings []*model.PluginSetting
+	if manifest.SettingsSchema != nil {
+		settings = manifest.SettingsSchema.Settings
+		for _, section := range manifest.SettingsSchema.Sections {
+			settings = append(settings, section.Settings...)
+		}
+	}
+
+	var fields, defaults, checks strings.Builder
+	for _, setting := range settings {
+		if setting.Type == "custom" {
+			continue
+		}
+
+		goType, ok := settingGoTypes[setting.Type]
+		if !ok {
+			return nil, errors.Errorf("setting %s has unsupported type %q", setting.Key, setting.Type)
+		}
+		if !token.IsIdentifier(setting.Key) || !token.IsExported(setting.Key) {
+			return nil, errors.Errorf("setting key %q must be an exported Go identifier, e.g. ClientID", setting.Key)
+		}
+
+		if setting.HelpText != "" {
+			fmt.Fprintf(&fields, "\t// %s\n", setting.HelpText)
+		}
+		fmt.Fprintf(&fields, "\t%s %s\n", setting.Key, goType)
+
+		if setting.Default != nil {
+			value, err := goLiteral(setting.Default, goType)
+			if err != nil {
+				return nil, errors.Wrapf(err, "setting %s has an invalid default", setting.Key)
+			}
+			fmt.Fprintf(&defaults, "\tdefault%s = %s\n", setting.Key, value)
+		}
+
+		switch {
+		case goType == "int":
+			fmt.Fprintf(&checks, "\tif c.%[1]s < 0 {\n\t\treturn errors.Errorf(\"%[1]s must not be negative, got %%d\", c.%[1]s)\n\t}\n", setting.Key)
+		case len(setting.Options) > 0:
+			values := make([]string, 0, len(setting.Options))
+			quoted := make([]string, 0, len(setting.Options))
+			for _, option := range setting.Options {
+				values = append(values, option.Value)
+				quoted = append(quoted, strconv.Quote(option.Value))
+			}
+			message := strconv.Quote(fmt.Sprintf("%s must be one of %s, got %%q", setting.Key, strings.Join(values, ", ")))
+			fmt.Fprintf(&checks, "\tif c.%[1]s != \"\" && !slices.Contains([]string{%[2]s}, c.%[1]s) {\n\t\treturn errors.Errorf(%[3]s, c.%[1]s)\n\t}\n", setting.Key, strings.Join(quoted, ", "), message)
+		}
+	}
+
+	var src bytes.Buffer
+	src.WriteString(configurationGoFileHeader)
+	if checks.Len() > 0 {
+		src.WriteString("import (\n")
+		if strings.Contains(checks.String(), "slices.") {
+			src.WriteString("\t\"slices\"\n\n")
+		}
+		src.WriteString("\t\"github.com/pkg/errors\"\n)\n\n")
+	}
+
+	src.WriteString(`// Configuration captures the plugin's external configuration as exposed in the Mattermost server configuration.
+// Its fields are deserialized from the Mattermost server configuration in OnConfigurationChange.
+type Configuration struct {
+`)
+	src.WriteString(fields.String())
+	src.WriteString("}\n")
+
+	if defaults.Len() > 0 {
+		src.WriteString("\n// The defaults of the settings, as declared in plugin.json\nconst (\n")
+		src.WriteString(defaults.String())
+		src.WriteString(")\n")
+	}
+
+	src.WriteString("\n// checkSchema checks the configured values are allowed by the settings schema\nfunc (c *Configuration) checkSchema() error {\n")
+	src.WriteString(checks.String())
+	src.WriteString("\treturn nil\n}\n")
+
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to format generated configuration")
+	}
+	return formatted, nil
+}
+
+// goLiteral renders a setting's default value from the manifest as a Go literal of the setting's type
+func goLiteral(value any, goType string) (string, error) {
+	switch goType {
+	case "bool":
+		if b, ok := value.(bool); ok {
+			return strconv.FormatBool(b), nil
+		}
+	case "int":
+		if f, ok := value.(float64); ok && f == math.Trunc(f) {
+			return strconv.FormatInt(int64(f), 10), nil
+		}
+	case "string":
+		if s, ok := value.(string); ok {
+			return strconv.Quote(s), nil
+		}
+	}
+	return "", errors.Errorf("%v is not a %s", value, goType)
+}
+
+// applyConfiguration writes the Configuration struct generated from the manifest's settings schema
+func applyConfiguration(manifest *model.Manifest) error {
+	src, err := generateConfiguration(manifest)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(configurationGoFilePath, src, 0600); err != nil {
+		return errors.Wrapf(err, "failed to write %s", configurationGoFilePath)
+	}
+	return nil
+}
+
+// checkConfiguration checks the Configuration struct on disk matches the manifest's settings schema
+func checkConfiguration(manifest *model.Manifest) error {
+	src, err := generateConfiguration(manifest)
+	if err != nil {
+		return err
+	}
+
+	existing, err := os.ReadFile(configurationGoFilePath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %s", configurationGoFilePath)
+	}
+	if !bytes.Equal(existing, src) {
+		return errors.Errorf("%s doesn't match the settings_schema in plugin.json, run make apply to regenerate it", configurationGoFilePath)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestGenerateConfiguration(t *testing.T) {
+	for name, tc := range map[string]struct {
+		settings      []*model.PluginSetting
+		sections      []*model.PluginSettingsSection
+		expectedLines []string
+		missingLines  []string
+		expectedError string
+	}{
+		"setting types": {
+			settings: []*model.PluginSetting{
+				{Key: "Enabled", Type: "bool"},
+				{Key: "MaxTracks", Type: "number"},
+				{Key: "ClientID", Type: "text"},
+				{Key: "Notes", Type: "longtext"},
+				{Key: "Mode", Type: "dropdown"},
+				{Key: "Bot", Type: "username"},
+			},
+			expectedLines: []string{
+				"Enabled   bool",
+				"MaxTracks int",
+				"ClientID  string",
+				"Notes     string",
+				"Mode      string",
+				"Bot       string",
+			},
+		},
+		"settings in sections": {
+			settings: []*model.PluginSetting{{Key: "ClientID", Type: "text"}},
+			sections: []*model.PluginSettingsSection{
+				{Key: "History", Settings: []*model.PluginSetting{{Key: "HistoryRetentionDays", Type: "number"}}},
+			},
+			expectedLines: []string{
+				"ClientID             string",
+				"HistoryRetentionDays int",
+			},
+		},
+		"custom setting": {
+			settings: []*model.PluginSetting{
+				{Key: "ConnectionTest", Type: "custom"},
+				{Key: "ClientID", Type: "text"},
+			},
+			expectedLines: []string{"ClientID string"},
+			missingLines:  []string{"ConnectionTest"},
+		},
+		"help text": {
+			settings:      []*model.PluginSetting{{Key: "ClientID", Type: "text", HelpText: "Client ID from your Spotify Application"}},
+			expectedLines: []string{"// Client ID from your Spotify Application", "ClientID string"},
+		},
+		"defaults": {
+			settings: []*model.PluginSetting{
+				{Key: "Enabled", Type: "bool", Default: true},
+				{Key: "MaxTracks", Type: "number", Default: float64(500)},
+				{Key: "Mode", Type: "dropdown", Default: "all"},
+				{Key: "ClientID", Type: "text"},
+			},
+			expectedLines: []string{
+				"defaultEnabled   = true",
+				"defaultMaxTracks = 500",
+				"defaultMode      = \"all\"",
+			},
+			missingLines: []string{"defaultClientID"},
+		},
+		"number check": {
+			settings: []*model.PluginSetting{{Key: "MaxTracks", Type: "number"}},
+			expectedLines: []string{
+				"if c.MaxTracks < 0 {",
+				"return errors.Errorf(\"MaxTracks must not be negative, got %d\", c.MaxTracks)",
+			},
+		},
+		"options check": {
+			settings: []*model.PluginSetting{{Key: "Mode", Type: "dropdown", Options: []*model.PluginOption{
+				{DisplayName: "All", Value: "all"},
+				{DisplayName: "None", Value: "none"},
+			}}},
+			expectedLines: []string{
+				"\"slices\"",
+				"if c.Mode != \"\" && !slices.Contains([]string{\"all\", \"none\"}, c.Mode) {",
+				"return errors.Errorf(\"Mode must be one of all, none, got %q\", c.Mode)",
+			},
+		},
+		"no checks": {
+			settings:      []*model.PluginSetting{{Key: "ClientID", Type: "text"}},
+			expectedLines: []string{"return nil"},
+			missingLines:  []string{"import", "errors"},
+		},
+		"lowercase key": {
+			settings:      []*model.PluginSetting{{Key: "clientID", Type: "text"}},
+			expectedError: `setting key "clientID" must be an exported Go identifier, e.g. ClientID`,
+		},
+		"invalid key": {
+			settings:      []*model.PluginSetting{{Key: "Client-ID", Type: "text"}},
+			expectedError: `setting key "Client-ID" must be an exported Go identifier, e.g. ClientID`,
+		},
+		"unsupported type": {
+			settings:      []*model.PluginSetting{{Key: "Secret", Type: "password"}},
+			expectedError: `setting Secret has unsupported type "password"`,
+		},
+		"invalid default": {
+			settings:      []*model.PluginSetting{{Key: "MaxTracks", Type: "number", Default: "500"}},
+			expectedError: "setting MaxTracks has an invalid default: 500 is not a int",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			manifest := &model.Manifest{SettingsSchema: &model.PluginSettingsSchema{Settings: tc.settings, Sections: tc.sections}}
+
+			src, err := generateConfiguration(manifest)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			lines := strings.Split(string(src), "\n")
+			for i := range lines {
+				lines[i] = strings.TrimSpace(lines[i])
+			}
+			for _, expected := range tc.expectedLines {
+				found := false
+				for _, line := range lines {
+					if line == expected {
+						found = true
+						break
+					}
+				}
+				if !found {
+					t.Errorf("expected line %q in:\n%s", expected, src)
+				}
+			}
+			for _, missing := range tc.missingLines {
+				if strings.Contains(string(src), missing) {
+					t.Errorf("unexpected %q in:\n%s", missing, src)
+				}
+			}
+		})
+	}
+}
+
+func TestGoLiteral(t *testing.T) {
+	for name, tc := range map[string]struct {
+		value         any
+		goType        string
+		expected      string
+		expectedError bool
+	}{
+		"bool":              {value: false, goType: "bool", expected: "false"},
+		"int":               {value: float64(15), goType: "int", expected: "15"},
+		"string":            {value: "a \"quoted\" value", goType: "string", expected: `"a \"quoted\" value"`},
+		"fractional int":    {value: 1.5, goType: "int", expectedError: true},
+		"string as bool":    {value: "true", goType: "bool", expectedError: true},
+		"number as string":  {value: float64(1), goType: "string", expectedError: true},
+		"missing as number": {value: nil, goType: "int", expectedError: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			literal, err := goLiteral(tc.value, tc.goType)
+			if tc.expectedError {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", literal)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if literal != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, literal)
+			}
+		})
+	}
+}
@@ -90,6 +90,11 @@ func main() {
 		if err := manifest.IsValid(); err != nil {
 			panic("failed to check manifest: " + err.Error())
 		}
+		if manifest.HasServer() {
+			if err := checkConfiguration(manifest); err != nil {
+				panic("failed to check configuration: " + err.Error())
+			}
+		}
 
 	default:
 		panic("unrecognized command: " + cmd)
@@ -155,7 +160,7 @@ func dumpPluginVersion(manifest *model.Manifest) {
 	fmt.Printf("%s", manifest.Version)
 }
 
-// applyManifest propagates the plugin_id into the server and webapp folders, as necessary
+// applyManifest propagates the plugin_id and settings schema into the server and webapp folders, as necessary
 func applyManifest(manifest *model.Manifest) error {
 	if manifest.HasServer() {
 		// generate JSON representation of Manifest.
@@ -173,6 +178,11 @@ func applyManifest(manifest *model.Manifest) error {
 		); err != nil {
 			return errors.Wrap(err, "failed to write server/manifest.go")
 		}
+
+		// write the configuration struct generated from the settings schema
+		if err := applyConfiguration(manifest); err != nil {
+			return errors.Wrap(err, "failed to generate configuration")
+		}
 	}
 
 	if manifest.HasWebapp() {
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	"github.com/zmb3/spotify/v2"
+	spotifyauth "github.com/zmb3/spotify/v2/auth"
+)
+
+// Post actions handled by handleItemAction
+const (
+	itemActionSave  = "save"
+	itemActionQueue = "queue"
+	itemActionOpen  = "open"
+	itemActionPlay  = "play"
+	itemActionPost  = "post"
+	itemActionReply = "reply"
+)
+
+// newPostAction creates a button that calls back into the plugin's action routes with the given context. The
+// button's Id includes the ID of what it acts on, as Mattermost finds a pressed button by its Id across all the
+// attachments of a post, so buttons on different attachments must not share one. Spotify IDs are alphanumeric, as
+// Mattermost requires of action Ids.
+func newPostAction(action, name, itemID string, actionContext map[string]any) *model.PostAction {
+	return &model.PostAction{
+		Id:   action + itemID,
+		Type: model.PostActionTypeButton,
+		Name: name,
+		Integration: &model.PostActionIntegration{
+			URL:     "/plugins/" + pluginID + "/api/v1/actions/" + action,
+			Context: actionContext,
+		},
+	}
+}
+
+// itemActionContext returns the post action context identifying a Spotify item
+func itemActionContext(itemType, itemID, itemName, itemURL string) map[string]any {
+	return map[string]any{
+		"item_type": itemType,
+		"item_id":   itemID,
+		"item_name": itemName,
+		"item_url":  itemURL,
+	}
+}
+
+// trackActions returns the buttons attached to plugin posts about a track
+func trackActions(trackID, trackName, trackURL string) []*model.PostAction {
+	actionContext := itemActionContext("track", trackID, trackName, trackURL)
+
+	return []*model.PostAction{
+		newPostAction(itemActionSave, "Save to Liked Songs", trackID, actionContext),
+		newPostAction(itemActionQueue, "Add to queue", trackID, actionContext),
+		newPostAction(itemActionOpen, "Open in Spotify", trackID, actionContext),
+	}
+}
+
+// handleItemAction handles a button press on a post about a Spotify item, acting with the Spotify account of the user who pressed it
+func (p *Plugin) handleItemAction(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+
+	var request model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		p.API.LogError("Failed to decode action request", "error", err)
+		http.Error(w, "invalid action request", http.StatusBadRequest)
+		return
+	}
+
+	itemType, _ := request.Context["item_type"].(string)
+	itemID, _ := request.Context["item_id"].(string)
+	itemName, _ := request.Context["item_name"].(string)
+	itemURL, _ := request.Context["item_url"].(string)
+	if itemType == "" || itemID == "" {
+		p.API.LogError("Action request missing item", "context", request.Context)
+		http.Error(w, "missing item", http.StatusBadRequest)
+		return
+	}
+
+	var text string
+	switch action := mux.Vars(r)["action"]; action {
+	case itemActionSave:
+		text = p.runAsUser(userID, []string{spotifyauth.ScopeUserLibraryModify}, func(ctx context.Context, client *spotify.Client) (string, error) {
+			if err := client.AddTracksToLibrary(ctx, spotify.ID(itemID)); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("Saved **%s** to your Liked Songs.", itemName), nil
+		})
+	case itemActionQueue:
+		text = p.runAsUser(userID, playbackScopes, func(ctx context.Context, client *spotify.Client) (string, error) {
+			if err := client.QueueSong(ctx, spotify.ID(itemID)); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("Added **%s** to your Spotify queue.", itemName), nil
+		})
+	case itemActionPlay:
+		text = p.runAsUser(userID, playbackScopes, func(ctx context.Context, client *spotify.Client) (string, error) {
+			if err := client.PlayOpt(ctx, playOptions(itemType, itemID)); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("Playing **%s**.", itemName), nil
+		})
+	case itemActionOpen:
+		text = fmt.Sprintf("[Open **%s** in Spotify](%s)", itemName, itemURL)
+	case itemActionPost:
+		if err := p.postItem(userID, request.ChannelId, spotifyLink{Type: itemType, ID: itemID}); err != nil {
+			p.API.LogError("Failed to post item", "userID", userID, "channelID", request.ChannelId, "error", err)
+			text = "Failed to post to the channel: " + err.Error()
+		} else {
+			text = fmt.Sprintf("Posted **%s** to the channel.", itemName)
+		}
+	case itemActionReply:
+		recommendationID, _ := request.Context["recommendation_id"].(string)
+		if err := p.openRecommendationReplyDialog(userID, request.TriggerId, recommendationID); err != nil {
+			p.API.LogError("Failed to open reply dialog", "userID", userID, "error", err)
+			text = "Failed to open reply dialog: " + err.Error()
+		}
+	default:
+		p.API.LogError("Unknown action", "action", action)
+		http.NotFound(w, r)
+		return
+	}
+
+	p.writeActionResponse(w, text)
+}
+
+// playOptions returns the options to start playing a Spotify item, either as a single track or as a context
+func playOptions(itemType, itemID string) *spotify.PlayOptions {
+	uri := spotify.URI("spotify:" + itemType + ":" + itemID)
+	if itemType == "track" || itemType == "episode" {
+		return &spotify.PlayOptions{URIs: []spotify.URI{uri}}
+	}
+	return &spotify.PlayOptions{PlaybackContext: &uri}
+}
+
+// postItem posts a preview of a Spotify item into a channel on behalf of a user
+func (p *Plugin) postItem(userID, channelID string, link spotifyLink) error {
+	if !p.client.User.HasPermissionToChannel(userID, channelID, model.PermissionCreatePost) {
+		return errors.New("you don't have permission to post in this channel")
+	}
+
+	if p.getAppClient() == nil {
+		return errors.New("Spotify not configured")
+	}
+
+	preview, err := p.getLinkPreview(context.Background(), link)
+	if err != nil {
+		return err
+	}
+
+	post := &model.Post{
+		UserId:    userID,
+		ChannelId: channelID,
+		Message:   preview.URL,
+	}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{linkPreviewAttachment(preview)})
+
+	return p.client.Post.CreatePost(post)
+}
+
+// writeActionResponse responds to a post action with an ephemeral message for the user who triggered it
+func (p *Plugin) writeActionResponse(w http.ResponseWriter, text string) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&model.PostActionIntegrationResponse{EphemeralText: text}); err != nil {
+		p.API.LogError("Failed to encode action response", "error", err)
+	}
+}
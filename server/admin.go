@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// maxAdminListUsers limits how many connected users are listed by /spotify admin list
+const maxAdminListUsers = 100
+
+// adminUserRow is a connected user listed by /spotify admin list
+type adminUserRow struct {
+	username string
+	row      string
+}
+
+// Command Plugin API - lists the users connected to Spotify with the state of their connection, for admins
+func (p *Plugin) AdminListUsers() (string, error) {
+	userIDs, err := p.kvstore.ListConnectedUserIDs()
+	if err != nil {
+		return "", err
+	}
+	if len(userIDs) == 0 {
+		return "No users are connected to Spotify.", nil
+	}
+
+	now := time.Now()
+	rows := make([]adminUserRow, 0, len(userIDs))
+	for _, userID := range userIDs {
+		username := userID
+		if user, err := p.client.User.Get(userID); err == nil {
+			username = user.Username
+		}
+
+		info, err := p.kvstore.GetConnectionInfo(userID)
+		if err != nil {
+			p.API.LogError("Failed to get connection info", "userID", userID, "error", err)
+			continue
+		}
+
+		account := info.SpotifyDisplayName
+		if account == "" {
+			account = "Unknown"
+		}
+		lastFetch := "Never"
+		if info.LastFetchAt != 0 {
+			lastFetch = formatTimeAgo(time.UnixMilli(info.LastFetchAt), now)
+		}
+		lastError := "None"
+		if info.LastError != "" {
+			lastError = fmt.Sprintf("%s, %s", strings.ReplaceAll(info.LastError, "|", "\\|"), formatTimeAgo(time.UnixMilli(info.LastErrorAt), now))
+		}
+
+		rows = append(rows, adminUserRow{
+			username: username,
+			row:      fmt.Sprintf("| @%s | %s | %s | %s |\n", username, account, lastFetch, lastError),
+		})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].username < rows[j].username })
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "#### Connected users (%d)\n", len(rows))
+	sb.WriteString("| User | Spotify account | Last successful fetch | Last error |\n|:--|:--|:--|:--|\n")
+	for i, row := range rows {
+		if i == maxAdminListUsers {
+			fmt.Fprintf(&sb, "\nAnd %d more.", len(rows)-maxAdminListUsers)
+			break
+		}
+		sb.WriteString(row.row)
+	}
+
+	return sb.String(), nil
+}
+
+// Command Plugin API - disconnects a user's Spotify account and deletes their data, for admins
+func (p *Plugin) AdminDisconnectUser(adminUserID, mention string) (string, error) {
+	user, err := p.client.User.GetByUsername(strings.TrimPrefix(mention, "@"))
+	if err != nil {
+		return "", errors.Errorf("user %s not found", mention)
+	}
+
+	token, err := p.kvstore.GetToken(user.Id)
+	if err != nil {
+		return "", err
+	}
+	if token == nil {
+		return "", errors.Errorf("@%s isn't connected to Spotify", user.Username)
+	}
+
+	if err := p.ClearUserData(user.Id); err != nil {
+		return "", errors.Wrap(err, "failed to clear user data")
+	}
+
+	admin, err := p.client.User.Get(adminUserID)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get admin")
+	}
+	p.notifyAdminDisconnected(user.Id, admin.Username)
+
+	p.API.LogInfo("Admin disconnected user", "adminUserID", adminUserID, "userID", user.Id)
+
+	return fmt.Sprintf("Disconnected @%s from Spotify and deleted their data. They've been sent a message explaining how to reconnect.", user.Username), nil
+}
+
+// Command Plugin API - clears a user's cached status so it's fetched from Spotify again, for admins
+func (p *Plugin) AdminRefreshUser(mention string) (string, error) {
+	user, err := p.client.User.GetByUsername(strings.TrimPrefix(mention, "@"))
+	if err != nil {
+		return "", errors.Errorf("user %s not found", mention)
+	}
+
+	if err := p.ClearStatusCache(user.Id); err != nil {
+		return "", errors.Wrap(err, "failed to clear status cache")
+	}
+
+	return fmt.Sprintf("Cleared the status cache for @%s.", user.Username), nil
+}
+
+// Command Plugin API - summarises how the plugin is being used, for admins
+func (p *Plugin) AdminStats() (string, error) {
+	userIDs, err := p.kvstore.ListConnectedUserIDs()
+	if err != nil {
+		return "", err
+	}
+	channelIDs, err := p.kvstore.ListChannelPlaylistChannelIDs()
+	if err != nil {
+		return "", err
+	}
+
+	activeSince := model.GetMillisForTime(time.Now().Add(-24 * time.Hour))
+	active, failing, hidden := 0, 0, 0
+	for _, userID := range userIDs {
+		info, err := p.kvstore.GetConnectionInfo(userID)
+		if err != nil {
+			p.API.LogError("Failed to get connection info", "userID", userID, "error", err)
+			continue
+		}
+		if info.LastFetchAt >= activeSince {
+			active++
+		}
+		if info.LastError != "" {
+			failing++
+		}
+
+		settings, err := p.kvstore.GetUserSettings(userID)
+		if err != nil {
+			p.API.LogError("Failed to get user settings", "userID", userID, "error", err)
+			continue
+		}
+		if settings.Visibility == visibilityNobody {
+			hidden++
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("#### Spotify plugin stats\n")
+	sb.WriteString("| | |\n|:--|--:|\n")
+	fmt.Fprintf(&sb, "| Connected users | %d |\n", len(userIDs))
+	fmt.Fprintf(&sb, "| Status fetched in the last 24 hours | %d |\n", active)
+	fmt.Fprintf(&sb, "| Connections with errors | %d |\n", failing)
+	fmt.Fprintf(&sb, "| Hiding their listening | %d |\n", hidden)
+	fmt.Fprintf(&sb, "| Channels with a linked playlist | %d |\n", len(channelIDs))
+
+	return sb.String(), nil
+}
@@ -5,14 +5,16 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"slices"
 	"strings"
-	"time"
 
 	"github.com/clearstargroup/cs-mattermost-spotify-plugin/server/store/kvstore"
 	"github.com/gorilla/mux"
+	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
 	"github.com/pkg/errors"
 	"github.com/zmb3/spotify/v2"
+	"golang.org/x/oauth2"
 )
 
 // MatterMost plugin hook - invoked when an HTTP request is received.
@@ -26,7 +28,16 @@ func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Req
 	apiRouter := router.PathPrefix("/api/v1").Subrouter()
 	apiRouter.Use(p.MattermostAuthorizationRequired)
 
+	apiRouter.HandleFunc("/connect", p.handleConnect).Methods(http.MethodGet)
 	apiRouter.HandleFunc("/status/{userId}", p.handleStatus).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/channels/{channelId}/listening", p.handleChannelListening).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/me/history", p.handleHistory).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/me/history/import", p.handleHistoryImport).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/actions/{action}", p.handleItemAction).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/autocomplete/{source}", p.handleAutocomplete).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/dialogs/recommendation-reply", p.handleRecommendationReply).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/dialogs/settings", p.handleSettingsDialog).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/admin/connection-test", p.handleConnectionTest).Methods(http.MethodPost)
 
 	router.ServeHTTP(w, r)
 }
@@ -45,7 +56,8 @@ func (p *Plugin) MattermostAuthorizationRequired(next http.Handler) http.Handler
 
 // handleSpotifyCallback handles the OAuth callback from Spotify
 func (p *Plugin) handleSpotifyCallback(w http.ResponseWriter, r *http.Request) {
-	if p.auth == nil {
+	auth := p.getAuth()
+	if auth == nil {
 		p.API.LogError("Spotify not configured")
 		http.Error(w, "Spotify not configured", http.StatusInternalServerError)
 		return
@@ -58,14 +70,14 @@ func (p *Plugin) handleSpotifyCallback(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ctx := context.Background()
-	tok, err := p.auth.Token(ctx, "123", r)
+	tok, err := auth.Token(ctx, "123", r)
 	if err != nil {
 		p.API.LogError("Failed to get token", "error", err)
 		http.Error(w, "Couldn't get token", http.StatusForbidden)
 		return
 	}
 
-	httpClient := p.auth.Client(ctx, tok)
+	httpClient := auth.Client(ctx, tok)
 	cli := spotify.New(httpClient)
 	cu, err := cli.CurrentUser(ctx)
 	if err != nil {
@@ -97,6 +109,15 @@ func (p *Plugin) handleSpotifyCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Store the granted scopes, which are only reported when the token is issued
+	if scope, ok := tok.Extra("scope").(string); ok {
+		if err := p.kvstore.StoreScopes(userID, strings.Fields(scope)); err != nil {
+			p.API.LogError("Failed to store scopes", "error", err)
+			http.Error(w, "failed to store scopes", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Clear the users status cache
 	if err := p.kvstore.StoreCacheStatus(userID, nil); err != nil {
 		p.API.LogError("Failed to clear cached status", "error", err)
@@ -104,6 +125,16 @@ func (p *Plugin) handleSpotifyCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Record the connection for diagnostics
+	p.updateConnectionInfo(userID, func(info *kvstore.ConnectionInfo) {
+		info.SpotifyDisplayName = cu.DisplayName
+		info.ConnectedAt = model.GetMillis()
+		info.LastError = ""
+	})
+
+	// Let the user know their account is connected
+	p.notifyConnected(userID, cu.DisplayName)
+
 	w.WriteHeader(http.StatusOK)
 	if _, err := w.Write([]byte("Successfully connected to Spotify! You can close this window.")); err != nil {
 		p.API.LogError("Failed to write response", "error", err)
@@ -114,6 +145,42 @@ func (p *Plugin) handleSpotifyCallback(w http.ResponseWriter, r *http.Request) {
 	p.API.LogInfo("Successfully handled Spotify callback", "email", cu.Email, "userID", userID)
 }
 
+// handleConnect redirects the requesting user to Spotify to (re)authorize their registered account
+func (p *Plugin) handleConnect(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+
+	// The user must have registered their Spotify email before authorizing
+	if _, err := p.kvstore.GetEmailByUserID(userID); err != nil {
+		p.API.LogError("No email registered for user", "userID", userID, "error", err)
+		http.Error(w, "no Spotify email registered, run /spotify enable your@spotifyemail.com in Mattermost first", http.StatusBadRequest)
+		return
+	}
+
+	auth := p.getAuth()
+	if auth == nil {
+		p.API.LogError("Spotify not configured")
+		http.Error(w, "Spotify not configured", http.StatusInternalServerError)
+		return
+	}
+
+	// Request the scopes already granted along with any additional scopes a feature needs
+	scopes, err := p.getGrantedScopes(userID)
+	if err != nil {
+		p.API.LogError("Failed to get granted scopes", "userID", userID, "error", err)
+		http.Error(w, "failed to get granted scopes", http.StatusInternalServerError)
+		return
+	}
+	scopes = slices.Clone(scopes)
+	for _, scope := range slices.Concat(baseScopes, r.URL.Query()["scope"]) {
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	url := auth.AuthURL("123", oauth2.SetAuthURLParam("scope", strings.Join(scopes, " ")))
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
 // handleStatus returns the Spotify player status for any user, fetching and caching if necessary
 func (p *Plugin) handleStatus(w http.ResponseWriter, r *http.Request) {
 	userID := mux.Vars(r)["userId"]
@@ -123,32 +190,14 @@ func (p *Plugin) handleStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Try to get cached status first
-	status, err := p.kvstore.GetCachedStatus(userID)
+	// Get the cached or current status, respecting the user's visibility settings
+	status, err := p.getVisibleStatus(r.Header.Get("Mattermost-User-ID"), userID)
 	if err != nil {
-		p.API.LogError("Failed to get cached status", "error", err)
-		http.Error(w, "failed to get cached status", http.StatusInternalServerError)
+		p.API.LogError("Failed to get status", "userID", userID, "error", err)
+		http.Error(w, "failed to get status", http.StatusInternalServerError)
 		return
 	}
 
-	// If no cached status, fetch fresh status from Spotify
-	if status == nil {
-		status, err = p.fetchStatus(userID)
-		if err != nil {
-			p.API.LogError("Failed to fetch status", "error", err)
-			http.Error(w, "failed to fetch status", http.StatusInternalServerError)
-			return
-		}
-
-		// Cache status
-		err = p.kvstore.StoreCacheStatus(userID, status)
-		if err != nil {
-			p.API.LogError("Failed to cache status", "error", err)
-			http.Error(w, "failed to cache status", http.StatusInternalServerError)
-			return
-		}
-	}
-
 	// Return status
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -161,80 +210,155 @@ func (p *Plugin) handleStatus(w http.ResponseWriter, r *http.Request) {
 	p.API.LogInfo("Successfully returned status", "userID", userID, "status", status)
 }
 
-// fetches the Spotify status for a user
+// fetches the Spotify status for a user, recording the outcome for diagnostics
 func (p *Plugin) fetchStatus(userID string) (*kvstore.Status, error) {
-	if p.auth == nil {
-		return nil, errors.New("Spotify not configured")
+	status, err := p.fetchPlayerStatus(userID)
+	if status == nil || status.IsConnected {
+		p.updateConnectionInfo(userID, func(info *kvstore.ConnectionInfo) {
+			if err != nil {
+				info.LastError = err.Error()
+				info.LastErrorAt = model.GetMillis()
+				return
+			}
+			info.LastFetchAt = model.GetMillis()
+			info.LastError = ""
+		})
 	}
 
+	return status, err
+}
+
+// fetches the Spotify player status for a user
+func (p *Plugin) fetchPlayerStatus(userID string) (*kvstore.Status, error) {
 	ctx := context.Background()
 
-	// Get token from KV store for the target user
-	tok, err := p.kvstore.GetToken(userID)
+	client, err := p.getSpotifyClient(ctx, userID)
 	if err != nil {
-		return nil, errors.Wrap(err, "error reading token for user")
+		return nil, err
 	}
 
-	// If no token, return not playing
-	if tok == nil {
+	// If no client, the user is not connected
+	if client == nil {
 		return &kvstore.Status{IsConnected: false}, nil
 	}
 
-	// Refresh token if it's expiring soon (within 5m30s)
-	if m, _ := time.ParseDuration("5m30s"); time.Until(tok.Expiry) < m {
-		newToken, tokenErr := p.auth.RefreshToken(ctx, tok)
-		if tokenErr != nil || newToken == nil {
-			return nil, errors.Wrap(tokenErr, "failed to refresh token")
+	// Get player state, asking for podcast episodes to be returned as items too
+	status, err := client.PlayerState(ctx, spotify.AdditionalTypes(spotify.EpisodeAdditionalType))
+	if err != nil || status == nil {
+		return nil, errors.Wrap(err, "failed to get player state")
+	}
+
+	// Handle not playing state, including what the user last listened to if it was recent enough
+	if !status.Playing {
+		p.API.LogInfo("Successfully fetched status - no token", "userID", userID)
+		return p.getLastListenedStatus(userID), nil
+	}
+
+	// Get additional context info based on what's currently playing
+	contextName, err := p.getContextName(ctx, client, status.PlaybackContext)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the status result
+	statusResult := &kvstore.Status{
+		IsConnected:  true,
+		IsPlaying:    true,
+		PlaybackURL:  status.PlaybackContext.ExternalURLs["spotify"],
+		PlaybackName: contextName,
+	}
+	if status.PlaybackContext.Type != "" {
+		statusResult.PlaybackType = strings.ToUpper(string(status.PlaybackContext.Type[0])) + status.PlaybackContext.Type[1:]
+	}
+
+	if status.Item != nil {
+		statusResult.ItemType = status.Item.Type
+	}
+
+	// Include details of the track itself when available. Episodes are only marked as such, as they aren't recorded
+	// in the history or shown in custom statuses.
+	if track := status.Item; track != nil && track.Type != itemTypeEpisode {
+		statusResult.TrackID = string(track.ID)
+		statusResult.TrackName = track.Name
+		statusResult.TrackURL = track.ExternalURLs["spotify"]
+		statusResult.TrackArtists = joinArtistNames(track.Artists)
+		statusResult.AlbumName = track.Album.Name
+		statusResult.ImageURL = firstImageURL(track.Album.Images)
+
+		// Record the play in the user's listening history
+		if err := p.recordPlay(userID, status); err != nil {
+			p.API.LogError("Failed to record play", "userID", userID, "error", err)
 		}
 
-		// Store refreshed token
-		err = p.kvstore.StoreToken(userID, newToken)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to store refreshed token")
+		if err := p.syncCustomStatus(userID, status); err != nil {
+			p.API.LogError("Failed to sync custom status", "userID", userID, "error", err)
 		}
 	}
 
-	httpClient := p.auth.Client(ctx, tok)
-	client := spotify.New(httpClient)
+	// Remember the status so it can be shown once playback stops
+	lastPlayed := *statusResult
+	lastPlayed.LastPlayedAt = model.GetMillis()
+	if err := p.kvstore.StoreLastPlayed(userID, &lastPlayed); err != nil {
+		p.API.LogError("Failed to store last played status", "userID", userID, "error", err)
+	}
 
-	// Get player state
-	status, err := client.PlayerState(ctx)
-	if err != nil || status == nil {
-		return nil, errors.Wrap(err, "failed to get player state")
+	p.API.LogInfo("Successfully fetched status", "userID", userID, "status", statusResult)
+
+	return statusResult, nil
+}
+
+// getLastListenedStatus returns the status of a connected user who isn't playing, describing what they last
+// listened to unless that was longer ago than the configured maximum age
+func (p *Plugin) getLastListenedStatus(userID string) *kvstore.Status {
+	notPlaying := &kvstore.Status{IsConnected: true, IsPlaying: false}
+
+	lastPlayed, err := p.kvstore.GetLastPlayed(userID)
+	if err != nil {
+		p.API.LogError("Failed to get last played status", "userID", userID, "error", err)
+		return notPlaying
 	}
 
-	// Handle not playing state
-	if !status.Playing {
-		p.API.LogInfo("Successfully fetched status - no token", "userID", userID)
-		return &kvstore.Status{IsConnected: true, IsPlaying: false}, nil
+	maxAge := int64(p.getLastListenedMaxAgeMinutes()) * 60 * 1000
+	if lastPlayed == nil || model.GetMillis()-lastPlayed.LastPlayedAt > maxAge {
+		return notPlaying
 	}
 
-	// Get additional context info based on what's currently playing
-	var contextName string
-	var ID = spotify.ID(strings.Split(string(status.PlaybackContext.URI), ":")[2])
+	lastPlayed.IsConnected = true
+	lastPlayed.IsPlaying = false
+	return lastPlayed
+}
+
+// getContextName returns the display name of a playback context (artist, playlist, album or show), using the cache where possible
+func (p *Plugin) getContextName(ctx context.Context, client *spotify.Client, playbackContext spotify.PlaybackContext) (string, error) {
+	// Contexts are identified by URIs of the form spotify:{type}:{id}
+	uriParts := strings.Split(string(playbackContext.URI), ":")
+	if len(uriParts) != 3 {
+		return "", nil
+	}
+	var ID = spotify.ID(uriParts[2])
 
 	// Try to get cached context name first
-	contextName, err = p.kvstore.GetContextName(status.PlaybackContext.Type, string(ID))
+	contextName, err := p.kvstore.GetContextName(playbackContext.Type, string(ID))
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get cached context name")
+		return "", errors.Wrap(err, "failed to get cached context name")
 	}
 
 	// If not in cache, fetch from Spotify API
 	if contextName == "" {
 		// Cache miss - fetch from Spotify API
-		switch status.PlaybackContext.Type {
+		switch playbackContext.Type {
 		case "artist":
 			artist, err := client.GetArtist(ctx, ID)
 			if err != nil || artist == nil {
-				return nil, errors.Wrap(err, "failed to get artist")
+				return "", errors.Wrap(err, "failed to get artist")
 			}
 			contextName = artist.Name
 		case "playlist":
 			playlist, err := client.GetPlaylist(ctx, ID)
 			switch {
-			case err != nil && err.Error() == "Resource not found" && status.PlaybackContext.ExternalURLs["spotify"] != "":
+			case err != nil && err.Error() == "Resource not found" && playbackContext.ExternalURLs["spotify"] != "":
 				var resp *http.Response
-				resp, err = http.Get(status.PlaybackContext.ExternalURLs["spotify"])
+				resp, err = http.Get(playbackContext.ExternalURLs["spotify"])
 				if err == nil && resp != nil {
 					defer resp.Body.Close()
 					var body []byte
@@ -248,45 +372,48 @@ func (p *Plugin) fetchStatus(userID string) (*kvstore.Status, error) {
 					}
 				}
 			case err != nil || playlist == nil:
-				return nil, errors.Wrap(err, "failed to get playlist")
+				return "", errors.Wrap(err, "failed to get playlist")
 			default:
 				contextName = playlist.Name
 			}
 		case "album":
 			album, err := client.GetAlbum(ctx, ID)
 			if err != nil || album == nil {
-				return nil, errors.Wrap(err, "failed to get album")
+				return "", errors.Wrap(err, "failed to get album")
 			}
 			contextName = album.Name + " - " + album.Artists[0].Name
 		case "show":
 			show, err := client.GetShow(ctx, ID)
 			if err != nil || show == nil {
-				return nil, errors.Wrap(err, "failed to get show")
+				return "", errors.Wrap(err, "failed to get show")
 			}
 			contextName = show.Name
 		}
 
 		// Cache the fetched context name for future use
 		if contextName != "" {
-			if err := p.kvstore.StoreContextName(status.PlaybackContext.Type, string(ID), contextName); err != nil {
-				p.API.LogError("Failed to cache context name", "type", status.PlaybackContext.Type, "id", ID, "error", err)
+			if err := p.kvstore.StoreContextName(playbackContext.Type, string(ID), contextName); err != nil {
+				p.API.LogError("Failed to cache context name", "type", playbackContext.Type, "id", ID, "error", err)
 				// Don't return error - just log it and continue
 			}
 		}
 
-		p.API.LogInfo("Successfully fetched context name", "type", status.PlaybackContext.Type, "id", ID, "name", contextName)
+		p.API.LogInfo("Successfully fetched context name", "type", playbackContext.Type, "id", ID, "name", contextName)
 	}
 
-	// Create the status result
-	statusResult := &kvstore.Status{
-		IsConnected:  true,
-		IsPlaying:    true,
-		PlaybackType: strings.ToUpper(string(status.PlaybackContext.Type[0])) + status.PlaybackContext.Type[1:],
-		PlaybackURL:  status.PlaybackContext.ExternalURLs["spotify"],
-		PlaybackName: contextName,
-	}
+	return contextName, nil
+}
 
-	p.API.LogInfo("Successfully fetched status", "userID", userID, "status", statusResult)
+// joinArtistNames returns a comma separated list of artist names
+func joinArtistNames(artists []spotify.SimpleArtist) string {
+	return strings.Join(artistNames(artists), ", ")
+}
 
-	return statusResult, nil
+// artistNames returns the names of artists
+func artistNames(artists []spotify.SimpleArtist) []string {
+	names := make([]string, 0, len(artists))
+	for _, artist := range artists {
+		names = append(names, artist.Name)
+	}
+	return names
 }
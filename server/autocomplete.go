@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	"github.com/zmb3/spotify/v2"
+)
+
+// maxAutocompleteItems limits how many suggestions are returned for a dynamic autocomplete argument
+const maxAutocompleteItems = 25
+
+// autocompleteSource lists the suggestions for a dynamic slash command argument
+type autocompleteSource func(p *Plugin, userID string, r *http.Request) ([]model.AutocompleteListItem, error)
+
+// autocompleteSources maps the sources served by /api/v1/autocomplete/{source} to their suggestions
+var autocompleteSources = map[string]autocompleteSource{
+	"devices":   (*Plugin).autocompleteDevices,
+	"playlists": (*Plugin).autocompletePlaylists,
+	"recent":    (*Plugin).autocompleteRecentTracks,
+	"members":   (*Plugin).autocompleteMembers,
+}
+
+// handleAutocomplete returns slash command autocomplete suggestions for the requesting user from a live source
+func (p *Plugin) handleAutocomplete(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	source := mux.Vars(r)["source"]
+
+	listItems, ok := autocompleteSources[source]
+	if !ok {
+		http.Error(w, "unknown autocomplete source", http.StatusNotFound)
+		return
+	}
+
+	// Suggestions are best effort, so a failure shows no suggestions rather than an error
+	items, err := listItems(p, userID, r)
+	if err != nil {
+		p.API.LogError("Failed to get autocomplete suggestions", "source", source, "userID", userID, "error", err)
+	}
+	if items == nil {
+		items = []model.AutocompleteListItem{}
+	}
+	if len(items) > maxAutocompleteItems {
+		items = items[:maxAutocompleteItems]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(items); err != nil {
+		p.API.LogError("Failed to encode autocomplete response", "error", err)
+	}
+}
+
+// autocompleteDevices suggests the user's Spotify Connect devices
+func (p *Plugin) autocompleteDevices(userID string, _ *http.Request) ([]model.AutocompleteListItem, error) {
+	ctx := context.Background()
+	client, err := p.getSpotifyClient(ctx, userID)
+	if err != nil || client == nil {
+		return nil, err
+	}
+
+	devices, err := client.PlayerDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]model.AutocompleteListItem, 0, len(devices))
+	for _, device := range devices {
+		items = append(items, model.AutocompleteListItem{
+			Item:     device.Name,
+			Hint:     device.Type,
+			HelpText: fmt.Sprintf("Volume %d%%", int(device.Volume)),
+		})
+	}
+	return items, nil
+}
+
+// autocompletePlaylists suggests links to the playlists owned by the user's Spotify account
+func (p *Plugin) autocompletePlaylists(userID string, _ *http.Request) ([]model.AutocompleteListItem, error) {
+	ctx := context.Background()
+	client, err := p.getSpotifyClient(ctx, userID)
+	if err != nil || client == nil {
+		return nil, err
+	}
+
+	currentUser, err := client.CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	playlists, err := client.CurrentUsersPlaylists(ctx, spotify.Limit(50))
+	if err != nil {
+		return nil, err
+	}
+
+	var items []model.AutocompleteListItem
+	for _, playlist := range playlists.Playlists {
+		if playlist.Owner.ID != currentUser.ID {
+			continue
+		}
+		items = append(items, model.AutocompleteListItem{
+			Item:     playlist.ExternalURLs["spotify"],
+			Hint:     playlist.Name,
+			HelpText: fmt.Sprintf("%d tracks", playlist.Tracks.Total),
+		})
+	}
+	return items, nil
+}
+
+// autocompleteRecentTracks suggests links to the tracks in the user's recorded listening history, most recent first
+func (p *Plugin) autocompleteRecentTracks(userID string, _ *http.Request) ([]model.AutocompleteListItem, error) {
+	history, err := p.getHistory(userID, 0, maxHistoryLength)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []model.AutocompleteListItem
+	var seen []string
+	for _, entry := range history {
+		if entry.TrackURL == "" || slices.Contains(seen, entry.TrackID) {
+			continue
+		}
+		seen = append(seen, entry.TrackID)
+		items = append(items, model.AutocompleteListItem{
+			Item:     entry.TrackURL,
+			Hint:     entry.TrackName,
+			HelpText: entry.TrackArtists,
+		})
+	}
+	return items, nil
+}
+
+// autocompleteMembers suggests the members of the current team who have connected Spotify and whose username starts
+// with what the user has typed. Users are only suggested from a team the requesting user is a member of.
+func (p *Plugin) autocompleteMembers(userID string, r *http.Request) ([]model.AutocompleteListItem, error) {
+	teamID := r.URL.Query().Get("team_id")
+	if teamID == "" {
+		return nil, errors.New("team_id is required")
+	}
+	if !p.isTeamMember(teamID, userID) {
+		return nil, errors.New("requesting user is not a member of the team")
+	}
+	prefix := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(r.URL.Query().Get("user_input")), "@"))
+
+	userIDs, err := p.kvstore.ListConnectedUserIDs()
+	if err != nil {
+		return nil, err
+	}
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+	users, err := p.client.User.ListByUserIDs(userIDs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get users")
+	}
+
+	var candidates []*model.User
+	for _, user := range users {
+		if user.DeleteAt == 0 && strings.HasPrefix(user.Username, prefix) {
+			candidates = append(candidates, user)
+		}
+	}
+	slices.SortFunc(candidates, func(a, b *model.User) int {
+		return strings.Compare(a.Username, b.Username)
+	})
+
+	// Team membership is checked last, and only until enough suggestions are found
+	var items []model.AutocompleteListItem
+	for _, user := range candidates {
+		if len(items) == maxAutocompleteItems {
+			break
+		}
+		if !p.isTeamMember(teamID, user.Id) {
+			continue
+		}
+		items = append(items, model.AutocompleteListItem{
+			Item:     "@" + user.Username,
+			Hint:     user.GetDisplayName(model.ShowFullName),
+			HelpText: "Connected to Spotify",
+		})
+	}
+	return items, nil
+}
+
+// isTeamMember reports whether a user is a current member of a team
+func (p *Plugin) isTeamMember(teamID, userID string) bool {
+	member, err := p.client.Team.GetMember(teamID, userID)
+	return err == nil && member.DeleteAt == 0
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	botUsername    = "spotify"
+	botDisplayName = "Spotify"
+	botDescription = "Created by the Spotify plugin to send you updates about your Spotify connection."
+
+	// spotifyColor is the Spotify brand green used for message attachments
+	spotifyColor = "#1DB954"
+)
+
+// ensureBot creates the plugin bot account if it does not already exist and records its user ID
+func (p *Plugin) ensureBot() error {
+	botUserID, err := p.client.Bot.EnsureBot(&model.Bot{
+		Username:    botUsername,
+		DisplayName: botDisplayName,
+		Description: botDescription,
+	})
+	if err != nil {
+		return err
+	}
+
+	p.botUserID = botUserID
+	return nil
+}
+
+// sendBotDM sends a direct message from the plugin bot to a user, logging any failure
+func (p *Plugin) sendBotDM(userID string, post *model.Post) {
+	if p.botUserID == "" {
+		p.API.LogError("Unable to send direct message, bot not initialized", "userID", userID)
+		return
+	}
+
+	if err := p.client.Post.DM(p.botUserID, userID, post); err != nil {
+		p.API.LogError("Failed to send direct message", "userID", userID, "error", err)
+	}
+}
+
+// sendConnectionDM sends a connection lifecycle message to a user, with a link to (re)connect their Spotify account
+func (p *Plugin) sendConnectionDM(userID, message string) {
+	connectURL := p.reconnectURL()
+
+	post := &model.Post{Message: message}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{
+		{
+			Fallback: "Reconnect Spotify: " + connectURL,
+			Color:    spotifyColor,
+			Text:     fmt.Sprintf(":arrows_counterclockwise: **[Reconnect Spotify](%s)**", connectURL),
+		},
+	})
+
+	p.sendBotDM(userID, post)
+}
+
+// notifyConnected tells a user that their Spotify account was successfully connected
+func (p *Plugin) notifyConnected(userID, spotifyName string) {
+	p.sendConnectionDM(userID, fmt.Sprintf("You're connected to Spotify as **%s**. Your teammates can now see what you're listening to. If your status ever stops updating, reconnect below.", spotifyName))
+}
+
+// notifyGrantRevoked tells a user that Spotify no longer accepts their authorization
+func (p *Plugin) notifyGrantRevoked(userID string) {
+	p.sendConnectionDM(userID, "Your Spotify authorization was revoked or has expired, so your listening status is no longer shared. Reconnect below to start sharing again.")
+}
+
+// notifyAdminDisconnected tells a user that a system admin disconnected their Spotify account
+func (p *Plugin) notifyAdminDisconnected(userID, adminUsername string) {
+	p.sendConnectionDM(userID, fmt.Sprintf("System admin @%s disconnected your Spotify account, so your listening status is no longer shared. Run `/spotify enable` with your Spotify email and reconnect below to start sharing again.", adminUsername))
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/clearstargroup/cs-mattermost-spotify-plugin/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/pkg/errors"
+	"github.com/zmb3/spotify/v2"
+	spotifyauth "github.com/zmb3/spotify/v2/auth"
+)
+
+// channelPlaylistSummaryInterval is how often a summary of newly collected tracks is posted to each channel
+const channelPlaylistSummaryInterval = 7 * 24 * time.Hour
+
+// channelPlaylistScopes are the OAuth scopes the playlist owner must grant for tracks to be added to it
+var channelPlaylistScopes = []string{
+	spotifyauth.ScopePlaylistModifyPublic,
+	spotifyauth.ScopePlaylistModifyPrivate,
+}
+
+// Command Plugin API - links a playlist owned by the user's Spotify account to a channel
+func (p *Plugin) LinkChannelPlaylist(userID, channelID, playlist string) (string, error) {
+	if !p.isChannelAdmin(userID, channelID) {
+		return "", errors.New("only channel admins can link a playlist to this channel")
+	}
+
+	playlistID, ok := parseSpotifyID(playlist, "playlist")
+	if !ok {
+		return "", errors.Errorf("%s is not a Spotify playlist link", playlist)
+	}
+
+	return p.runAsUser(userID, channelPlaylistScopes, func(ctx context.Context, client *spotify.Client) (string, error) {
+		currentUser, err := client.CurrentUser(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		fullPlaylist, err := client.GetPlaylist(ctx, playlistID)
+		if err != nil {
+			return "", err
+		}
+
+		// Tracks are added with the linking user's account, so they must own the playlist
+		if fullPlaylist.Owner.ID != currentUser.ID {
+			return fmt.Sprintf("**%s** is owned by another Spotify account. Link a playlist you own so tracks can be added to it.", fullPlaylist.Name), nil
+		}
+
+		// Seed the tracks already in the playlist so they aren't added again
+		trackIDs, err := getPlaylistTrackIDs(ctx, client, playlistID, p.getChannelPlaylistMaxTracks())
+		if err != nil {
+			return "", err
+		}
+
+		channelPlaylist := &kvstore.ChannelPlaylist{
+			ChannelID:     channelID,
+			PlaylistID:    string(playlistID),
+			PlaylistName:  fullPlaylist.Name,
+			PlaylistURL:   fullPlaylist.ExternalURLs["spotify"],
+			OwnerUserID:   userID,
+			TrackIDs:      trackIDs,
+			LastSummaryAt: model.GetMillis(),
+		}
+		if err := p.kvstore.StoreChannelPlaylist(channelPlaylist); err != nil {
+			return "", err
+		}
+
+		p.API.LogInfo("Linked channel playlist", "channelID", channelID, "playlistID", playlistID, "userID", userID)
+
+		return fmt.Sprintf("Linked [%s](%s) to this channel. Spotify tracks posted here will now be added to it.", channelPlaylist.PlaylistName, channelPlaylist.PlaylistURL), nil
+	}), nil
+}
+
+// Command Plugin API - unlinks the playlist from a channel
+func (p *Plugin) UnlinkChannelPlaylist(userID, channelID string) error {
+	if !p.isChannelAdmin(userID, channelID) {
+		return errors.New("only channel admins can unlink the playlist from this channel")
+	}
+
+	return p.kvstore.DeleteChannelPlaylist(channelID)
+}
+
+// unlinkOwnedChannelPlaylists unlinks the playlists owned by a user from their channels, letting each channel know,
+// for when the user disconnects Spotify
+func (p *Plugin) unlinkOwnedChannelPlaylists(userID string) error {
+	channelIDs, err := p.kvstore.ListChannelPlaylistChannelIDs()
+	if err != nil {
+		return err
+	}
+
+	for _, channelID := range channelIDs {
+		if err := p.unlinkOwnedChannelPlaylist(userID, channelID); err != nil {
+			p.API.LogError("Failed to unlink channel playlist", "channelID", channelID, "userID", userID, "error", err)
+		}
+	}
+	return nil
+}
+
+// unlinkOwnedChannelPlaylist unlinks the playlist linked to a channel if it is owned by the user
+func (p *Plugin) unlinkOwnedChannelPlaylist(userID, channelID string) error {
+	unlock, err := p.lock("channel-playlist-lock-" + channelID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	channelPlaylist, err := p.kvstore.GetChannelPlaylist(channelID)
+	if err != nil || channelPlaylist == nil || channelPlaylist.OwnerUserID != userID {
+		return err
+	}
+	if err := p.kvstore.DeleteChannelPlaylist(channelID); err != nil {
+		return err
+	}
+
+	post := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: channelID,
+		Message:   fmt.Sprintf("[%s](%s) was unlinked from this channel, as its owner disconnected Spotify. Channel admins can link another playlist with `/spotify channel-playlist link <playlist URL>`.", channelPlaylist.PlaylistName, channelPlaylist.PlaylistURL),
+	}
+	if err := p.client.Post.CreatePost(post); err != nil {
+		return errors.Wrap(err, "failed to create unlink post")
+	}
+
+	p.API.LogInfo("Unlinked channel playlist of disconnected user", "channelID", channelID, "playlistID", channelPlaylist.PlaylistID, "userID", userID)
+	return nil
+}
+
+// Command Plugin API - describes the playlist linked to a channel
+func (p *Plugin) DescribeChannelPlaylist(channelID string) (string, error) {
+	channelPlaylist, err := p.kvstore.GetChannelPlaylist(channelID)
+	if err != nil {
+		return "", err
+	}
+
+	if channelPlaylist == nil {
+		return "No playlist is linked to this channel. Channel admins can link one with `/spotify channel-playlist link <playlist URL>`.", nil
+	}
+
+	return fmt.Sprintf("Spotify tracks posted in this channel are collected into [%s](%s) (%d of %d tracks).", channelPlaylist.PlaylistName, channelPlaylist.PlaylistURL, len(channelPlaylist.TrackIDs), p.getChannelPlaylistMaxTracks()), nil
+}
+
+// MatterMost plugin hook - invoked after a message is posted, attaching previews for Spotify links and collecting
+// Spotify tracks into the channel's linked playlist
+func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
+	p.unfurlSpotifyLinks(post)
+
+	var trackIDs []string
+	for _, link := range findSpotifyLinks(post.Message) {
+		if link.Type == "track" {
+			trackIDs = append(trackIDs, link.ID)
+		}
+	}
+
+	if len(trackIDs) == 0 {
+		return
+	}
+
+	if err := p.addChannelPlaylistTracks(post.ChannelId, trackIDs); err != nil {
+		p.API.LogError("Failed to add tracks to channel playlist", "channelID", post.ChannelId, "error", err)
+	}
+}
+
+// addChannelPlaylistTracks adds tracks to the playlist linked to a channel, skipping duplicates and respecting the track limit
+func (p *Plugin) addChannelPlaylistTracks(channelID string, trackIDs []string) error {
+	unlock, err := p.lock("channel-playlist-lock-" + channelID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	channelPlaylist, err := p.kvstore.GetChannelPlaylist(channelID)
+	if err != nil || channelPlaylist == nil {
+		return err
+	}
+
+	maxTracks := p.getChannelPlaylistMaxTracks()
+	var newTrackIDs []spotify.ID
+	for _, trackID := range trackIDs {
+		if len(channelPlaylist.TrackIDs) >= maxTracks {
+			p.API.LogInfo("Channel playlist is full", "channelID", channelID, "playlistID", channelPlaylist.PlaylistID)
+			break
+		}
+		if !slices.Contains(channelPlaylist.TrackIDs, trackID) {
+			channelPlaylist.TrackIDs = append(channelPlaylist.TrackIDs, trackID)
+			newTrackIDs = append(newTrackIDs, spotify.ID(trackID))
+		}
+	}
+
+	if len(newTrackIDs) == 0 {
+		return nil
+	}
+
+	ctx := context.Background()
+	client, err := p.getSpotifyClient(ctx, channelPlaylist.OwnerUserID)
+	if err != nil {
+		return err
+	}
+	if client == nil {
+		return errors.New("playlist owner is no longer connected to Spotify")
+	}
+
+	if _, err := client.AddTracksToPlaylist(ctx, spotify.ID(channelPlaylist.PlaylistID), newTrackIDs...); err != nil {
+		return errors.Wrap(err, "failed to add tracks to playlist")
+	}
+
+	channelPlaylist.AddedSinceSummary += len(newTrackIDs)
+	return p.kvstore.StoreChannelPlaylist(channelPlaylist)
+}
+
+// postChannelPlaylistSummaries posts a summary to each channel whose linked playlist is due one. Run by a scheduled job.
+func (p *Plugin) postChannelPlaylistSummaries() {
+	channelIDs, err := p.kvstore.ListChannelPlaylistChannelIDs()
+	if err != nil {
+		p.API.LogError("Failed to list channel playlists", "error", err)
+		return
+	}
+
+	for _, channelID := range channelIDs {
+		if err := p.postChannelPlaylistSummary(channelID); err != nil {
+			p.API.LogError("Failed to post channel playlist summary", "channelID", channelID, "error", err)
+		}
+	}
+}
+
+// postChannelPlaylistSummary posts a summary of the tracks added to a channel's playlist if a week has passed since the last one
+func (p *Plugin) postChannelPlaylistSummary(channelID string) error {
+	unlock, err := p.lock("channel-playlist-lock-" + channelID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	channelPlaylist, err := p.kvstore.GetChannelPlaylist(channelID)
+	if err != nil || channelPlaylist == nil {
+		return err
+	}
+
+	if time.Since(time.UnixMilli(channelPlaylist.LastSummaryAt)) < channelPlaylistSummaryInterval {
+		return nil
+	}
+
+	// Only post when something was collected, to avoid noise in quiet channels
+	if channelPlaylist.AddedSinceSummary > 0 {
+		post := &model.Post{
+			UserId:    p.botUserID,
+			ChannelId: channelID,
+			Message: fmt.Sprintf(":notes: %d new tracks from this channel were added to [%s](%s) this week, bringing it to %d tracks.",
+				channelPlaylist.AddedSinceSummary, channelPlaylist.PlaylistName, channelPlaylist.PlaylistURL, len(channelPlaylist.TrackIDs)),
+		}
+		if err := p.client.Post.CreatePost(post); err != nil {
+			return errors.Wrap(err, "failed to create summary post")
+		}
+	}
+
+	channelPlaylist.AddedSinceSummary = 0
+	channelPlaylist.LastSummaryAt = model.GetMillis()
+	return p.kvstore.StoreChannelPlaylist(channelPlaylist)
+}
+
+// getPlaylistTrackIDs returns the IDs of up to maxTracks tracks in a playlist
+func getPlaylistTrackIDs(ctx context.Context, client *spotify.Client, playlistID spotify.ID, maxTracks int) ([]string, error) {
+	page, err := client.GetPlaylistItems(ctx, playlistID)
+	if err != nil {
+		return nil, err
+	}
+
+	var trackIDs []string
+	for {
+		for _, item := range page.Items {
+			if item.Track.Track != nil && len(trackIDs) < maxTracks {
+				trackIDs = append(trackIDs, string(item.Track.Track.ID))
+			}
+		}
+
+		if len(trackIDs) >= maxTracks {
+			return trackIDs, nil
+		}
+
+		err = client.NextPage(ctx, page)
+		if errors.Is(err, spotify.ErrNoMorePages) {
+			return trackIDs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// isChannelAdmin reports whether a user may manage the plugin's settings for a channel
+func (p *Plugin) isChannelAdmin(userID, channelID string) bool {
+	if p.client.User.HasPermissionTo(userID, model.PermissionManageSystem) {
+		return true
+	}
+
+	member, err := p.client.Channel.GetMember(channelID, userID)
+	return err == nil && member.SchemeAdmin
+}
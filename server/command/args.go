@@ -0,0 +1,264 @@
+package command
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// token is a word of a command, after quotes and escapes are removed
+type token struct {
+	value string
+	// pos is the byte offset of the start of the token in the command, and end the offset just after it
+	pos int
+	end int
+}
+
+// parseError describes a problem parsing a command, at a byte offset into it
+type parseError struct {
+	pos     int
+	message string
+}
+
+func (e *parseError) Error() string {
+	return e.message
+}
+
+// format describes the error, pointing at where it occurred in the command
+func (e *parseError) format(command string) string {
+	caret := strings.Repeat(" ", utf8.RuneCountInString(command[:e.pos])) + "^"
+	return fmt.Sprintf("%s\n```\n%s\n%s\n```", e.message, command, caret)
+}
+
+// tokenize splits a command into words like a shell does. Words are separated by whitespace, which can be
+// included in a word by quoting it with single or double quotes or escaping it with a backslash. Nothing is
+// escaped inside single quotes; inside double quotes only quotes and backslashes can be escaped. Unlike a shell,
+// a single quote only starts a quoted string at the start of a word, so apostrophes in free text like "it's" are
+// kept as they are.
+func tokenize(command string) ([]token, error) {
+	var tokens []token
+	var current strings.Builder
+	inToken := false
+	start := 0
+
+	for i := 0; i < len(command); {
+		r, size := utf8.DecodeRuneInString(command[i:])
+
+		switch {
+		case unicode.IsSpace(r):
+			if inToken {
+				tokens = append(tokens, token{value: current.String(), pos: start, end: i})
+				current.Reset()
+				inToken = false
+			}
+			i += size
+			continue
+
+		case !inToken:
+			inToken = true
+			start = i
+		}
+
+		switch r {
+		case '\\':
+			if i+size >= len(command) {
+				return nil, &parseError{pos: i, message: "Nothing to escape after the backslash at the end of the command."}
+			}
+			escaped, escapedSize := utf8.DecodeRuneInString(command[i+size:])
+			current.WriteRune(escaped)
+			i += size + escapedSize
+
+		case '\'':
+			if i != start {
+				current.WriteRune(r)
+				i += size
+				break
+			}
+			end := strings.IndexRune(command[i+size:], '\'')
+			if end < 0 {
+				return nil, &parseError{pos: i, message: "Missing closing single quote."}
+			}
+			current.WriteString(command[i+size : i+size+end])
+			i += size + end + 1
+
+		case '"':
+			j := i + size
+			closed := false
+			for j < len(command) {
+				c := command[j]
+				if c == '"' {
+					closed = true
+					j++
+					break
+				}
+				if c == '\\' && j+1 < len(command) && (command[j+1] == '"' || command[j+1] == '\\') {
+					current.WriteByte(command[j+1])
+					j += 2
+					continue
+				}
+				current.WriteByte(c)
+				j++
+			}
+			if !closed {
+				return nil, &parseError{pos: i, message: "Missing closing double quote."}
+			}
+			i = j
+
+		default:
+			current.WriteRune(r)
+			i += size
+		}
+	}
+
+	if inToken {
+		tokens = append(tokens, token{value: current.String(), pos: start, end: len(command)})
+	}
+
+	return tokens, nil
+}
+
+// flagKind is the type of value a flag takes
+type flagKind int
+
+const (
+	flagBool flagKind = iota
+	flagString
+	flagInt
+)
+
+// flag describes a --flag option of a subcommand
+type flag struct {
+	name        string
+	description string
+	kind        flagKind
+	// values lists the accepted values of a string flag, if restricted
+	values []string
+}
+
+// hint returns how the flag is shown in usage text, e.g. [--limit <n>]
+func (f flag) hint() string {
+	switch {
+	case f.kind == flagBool:
+		return "[--" + f.name + "]"
+	case len(f.values) > 0:
+		return "[--" + f.name + " " + strings.Join(f.values, "|") + "]"
+	case f.kind == flagInt:
+		return "[--" + f.name + " <n>]"
+	default:
+		return "[--" + f.name + " <value>]"
+	}
+}
+
+// flagValues holds the flags given to a subcommand, which have been checked against their declared types
+type flagValues map[string]string
+
+// Bool returns whether a boolean flag was given
+func (f flagValues) Bool(name string) bool {
+	_, ok := f[name]
+	return ok
+}
+
+// String returns the value of a string flag, or an empty string if it wasn't given
+func (f flagValues) String(name string) string {
+	return f[name]
+}
+
+// Int returns the value of an integer flag, or zero if it wasn't given
+func (f flagValues) Int(name string) int {
+	value, _ := strconv.Atoi(f[name])
+	return value
+}
+
+// parseFlags separates a subcommand's --flag options from its positional arguments, checking each flag is
+// declared and has a value of the right type. A lone -- ends the flags, so later arguments may start with --.
+// It is only used for subcommands that declare flags, so free text given to other subcommands is left alone.
+func parseFlags(tokens []token, flags []flag) ([]token, flagValues, error) {
+	var positional []token
+	values := flagValues{}
+
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if tok.value == "--" {
+			positional = append(positional, tokens[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(tok.value, "--") {
+			positional = append(positional, tok)
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(tok.value, "--"), "=")
+		var declared *flag
+		for j := range flags {
+			if flags[j].name == name {
+				declared = &flags[j]
+				break
+			}
+		}
+		if declared == nil {
+			return nil, nil, &parseError{pos: tok.pos, message: fmt.Sprintf("Unknown flag --%s.", name)}
+		}
+
+		if declared.kind == flagBool {
+			if hasValue {
+				return nil, nil, &parseError{pos: tok.pos, message: fmt.Sprintf("Flag --%s doesn't take a value.", name)}
+			}
+			values[name] = ""
+			continue
+		}
+
+		valuePos := tok.pos
+		if !hasValue {
+			if i+1 >= len(tokens) {
+				return nil, nil, &parseError{pos: tok.pos, message: fmt.Sprintf("Flag --%s needs a value.", name)}
+			}
+			i++
+			value, valuePos = tokens[i].value, tokens[i].pos
+		}
+
+		if declared.kind == flagInt {
+			if _, err := strconv.Atoi(value); err != nil {
+				return nil, nil, &parseError{pos: valuePos, message: fmt.Sprintf("Flag --%s needs a whole number, not %q.", name, value)}
+			}
+		}
+		if len(declared.values) > 0 && !slices.Contains(declared.values, value) {
+			return nil, nil, &parseError{pos: valuePos, message: fmt.Sprintf("Flag --%s must be one of %s, not %q.", name, strings.Join(declared.values, ", "), value)}
+		}
+		values[name] = value
+	}
+
+	return positional, values, nil
+}
+
+// freeText returns the words of a variadic argument as they were typed in the command, keeping quotes, escapes and
+// whitespace, e.g. the lines of a message. A single word is returned without its quotes, so the whole text can be
+// quoted, and if flags were given among the words they are joined instead.
+func freeText(command string, tokens, words []token) string {
+	if len(words) == 1 {
+		return words[0].value
+	}
+
+	first, last := words[0], words[len(words)-1]
+	typed := 0
+	for _, tok := range tokens {
+		if tok.pos >= first.pos && tok.end <= last.end {
+			typed++
+		}
+	}
+	if typed != len(words) {
+		return strings.Join(tokenValues(words), " ")
+	}
+	return command[first.pos:last.end]
+}
+
+// tokenValues returns the values of tokens
+func tokenValues(tokens []token) []string {
+	values := make([]string, 0, len(tokens))
+	for _, tok := range tokens {
+		values = append(values, tok.value)
+	}
+	return values
+}
@@ -0,0 +1,210 @@
+package command
+
+import (
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	for name, tc := range map[string]struct {
+		command        string
+		expectedValues []string
+		expectedPos    []int
+		expectedErrPos int
+		expectedErr    bool
+	}{
+		"plain words": {
+			command:        "/spotify search never gonna",
+			expectedValues: []string{"/spotify", "search", "never", "gonna"},
+			expectedPos:    []int{0, 9, 16, 22},
+		},
+		"repeated whitespace": {
+			command:        "  a \t b  ",
+			expectedValues: []string{"a", "b"},
+			expectedPos:    []int{2, 6},
+		},
+		"double quotes": {
+			command:        `recommend @bob "great \"song\" \\o/"`,
+			expectedValues: []string{"recommend", "@bob", `great "song" \o/`},
+			expectedPos:    []int{0, 10, 15},
+		},
+		"single quotes are literal": {
+			command:        `a 'b \" c'`,
+			expectedValues: []string{"a", `b \" c`},
+			expectedPos:    []int{0, 2},
+		},
+		"quotes inside a word": {
+			command:        `--type="play"list x`,
+			expectedValues: []string{"--type=playlist", "x"},
+			expectedPos:    []int{0, 18},
+		},
+		"escaped space": {
+			command:        `a\ b c`,
+			expectedValues: []string{"a b", "c"},
+			expectedPos:    []int{0, 5},
+		},
+		"empty quotes": {
+			command:        `a "" b`,
+			expectedValues: []string{"a", "", "b"},
+			expectedPos:    []int{0, 2, 5},
+		},
+		"apostrophes inside words": {
+			command:        `share it's the Beatles' best`,
+			expectedValues: []string{"share", "it's", "the", "Beatles'", "best"},
+			expectedPos:    []int{0, 6, 11, 15, 24},
+		},
+		"single quotes after an apostrophe": {
+			command:        `you'll 'love this'`,
+			expectedValues: []string{"you'll", "love this"},
+			expectedPos:    []int{0, 7},
+		},
+		"unclosed double quote": {
+			command:        `a "b c`,
+			expectedErr:    true,
+			expectedErrPos: 2,
+		},
+		"unclosed single quote": {
+			command:        `a b 'c`,
+			expectedErr:    true,
+			expectedErrPos: 4,
+		},
+		"trailing backslash": {
+			command:        `a b\`,
+			expectedErr:    true,
+			expectedErrPos: 3,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tokens, err := tokenize(tc.command)
+			if tc.expectedErr {
+				parseErr, ok := err.(*parseError)
+				if !ok {
+					t.Fatalf("expected a parse error, got %v", err)
+				}
+				if parseErr.pos != tc.expectedErrPos {
+					t.Errorf("expected error at %d, got %d", tc.expectedErrPos, parseErr.pos)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			assertTokens(t, tc.expectedValues, tc.expectedPos, tokens)
+		})
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	flags := []flag{
+		{name: "type", kind: flagString, values: []string{"track", "album"}},
+		{name: "limit", kind: flagInt},
+		{name: "all", kind: flagBool},
+	}
+
+	for name, tc := range map[string]struct {
+		command            string
+		expectedPositional []string
+		expectedFlags      flagValues
+		expectedErrPos     int
+		expectedErr        bool
+	}{
+		"no flags": {
+			command:            "never gonna",
+			expectedPositional: []string{"never", "gonna"},
+			expectedFlags:      flagValues{},
+		},
+		"separate and joined values": {
+			command:            "never --type album gonna --limit=5",
+			expectedPositional: []string{"never", "gonna"},
+			expectedFlags:      flagValues{"type": "album", "limit": "5"},
+		},
+		"bool flag": {
+			command:            "--all never",
+			expectedPositional: []string{"never"},
+			expectedFlags:      flagValues{"all": ""},
+		},
+		"double dash ends flags": {
+			command:            "--limit 2 -- --all",
+			expectedPositional: []string{"--all"},
+			expectedFlags:      flagValues{"limit": "2"},
+		},
+		"unknown flag": {
+			command:        "never --colour red",
+			expectedErr:    true,
+			expectedErrPos: 6,
+		},
+		"missing value": {
+			command:        "never --limit",
+			expectedErr:    true,
+			expectedErrPos: 6,
+		},
+		"invalid int": {
+			command:        "--limit five",
+			expectedErr:    true,
+			expectedErrPos: 8,
+		},
+		"value not allowed": {
+			command:        "x --type=show",
+			expectedErr:    true,
+			expectedErrPos: 2,
+		},
+		"bool flag with value": {
+			command:        "--all=yes",
+			expectedErr:    true,
+			expectedErrPos: 0,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tokens, err := tokenize(tc.command)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			positional, values, err := parseFlags(tokens, flags)
+			if tc.expectedErr {
+				parseErr, ok := err.(*parseError)
+				if !ok {
+					t.Fatalf("expected a parse error, got %v", err)
+				}
+				if parseErr.pos != tc.expectedErrPos {
+					t.Errorf("expected error at %d, got %d", tc.expectedErrPos, parseErr.pos)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := tokenValues(positional)
+			if len(got) != len(tc.expectedPositional) {
+				t.Fatalf("expected positional %q, got %q", tc.expectedPositional, got)
+			}
+			for i := range got {
+				if got[i] != tc.expectedPositional[i] {
+					t.Errorf("expected positional %q, got %q", tc.expectedPositional, got)
+				}
+			}
+			if len(values) != len(tc.expectedFlags) {
+				t.Fatalf("expected flags %v, got %v", tc.expectedFlags, values)
+			}
+			for name, value := range tc.expectedFlags {
+				if got, ok := values[name]; !ok || got != value {
+					t.Errorf("expected flags %v, got %v", tc.expectedFlags, values)
+				}
+			}
+		})
+	}
+}
+
+func assertTokens(t *testing.T, expectedValues []string, expectedPos []int, got []token) {
+	t.Helper()
+
+	if len(expectedValues) != len(got) {
+		t.Fatalf("expected %d tokens, got %d: %v", len(expectedValues), len(got), got)
+	}
+	for i := range got {
+		if got[i].value != expectedValues[i] || got[i].pos != expectedPos[i] {
+			t.Errorf("expected token %q at %d, got %q at %d", expectedValues[i], expectedPos[i], got[i].value, got[i].pos)
+		}
+	}
+}
@@ -7,10 +7,35 @@ import (
 // PluginAPI defines the interface for accessing plugin-specific functionality
 type PluginAPI interface {
 	RegisterCommand(command *model.Command) error
+	HasPermissionTo(userID string, permission *model.Permission) bool
 	GetSpotifyAuthURL() (string, error)
 	StoreUserEmail(userID, email string) error
 	ClearUserData(userID string) error
 	ClearStatusCache(userID string) error
+	ShareCurrentTrack(userID, channelID, rootID, message string) error
+	LinkChannelPlaylist(userID, channelID, playlist string) (string, error)
+	UnlinkChannelPlaylist(userID, channelID string) error
+	DescribeChannelPlaylist(channelID string) (string, error)
+	ControlPlayback(userID, channelID, action, value string) string
+	ListDevices(userID string) string
+	TransferPlayback(userID, channelID, deviceName string) string
+	Search(userID, query string, types []string, limit int) (string, []*model.SlackAttachment)
+	IsSpotifyTrack(value string) bool
+	Recommend(userID, targetUsername, track, note string) (string, error)
+	ListRecommendations(userID string) (string, []*model.SlackAttachment, error)
+	SetAcceptRecommendations(userID string, accept bool) error
+	History(userID string, limit int) (string, error)
+	Top(userID, itemType, timeRange string) (string, []*model.SlackAttachment)
+	Recent(userID, targetUsername string) (string, error)
+	SetVisibility(userID, visibility string) error
+	OpenSettingsDialog(userID, triggerID string) error
+	WhoIsListening(userID, channelID string) (string, error)
+	UserStatus(userID, targetUsername string) (string, []*model.SlackAttachment, error)
+	Whoami(userID string) (string, error)
+	AdminListUsers() (string, error)
+	AdminDisconnectUser(adminUserID, mention string) (string, error)
+	AdminRefreshUser(mention string) (string, error)
+	AdminStats() (string, error)
 	LogInfo(message string, args ...any)
 }
 
@@ -1,7 +1,9 @@
 package command
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/mattermost/mattermost/server/public/model"
@@ -9,42 +11,228 @@ import (
 
 // Impl implements the Command interface
 type Impl struct {
-	pluginAPI PluginAPI
+	pluginAPI   PluginAPI
+	subcommands []*subcommand
 }
 
 const spotifyCommandTrigger = "spotify"
 
 // NewCommand creates a new Command handler and registers slash commands
 func NewCommand(pluginAPI PluginAPI) (Command, error) {
-	// Autocomplete data
-	autocompleteData := model.NewAutocompleteData("spotify", "", "Enables or disables Spotify integration.")
-	autocompleteData.AddStaticListArgument("", true, []model.AutocompleteListItem{
-		{
-			Item:     "enable",
-			HelpText: "Enable Spotify integration",
-		},
-		{
-			Item:     "disable",
-			HelpText: "Disable Spotify integration",
-		},
-		{
-			Item:     "refresh",
-			HelpText: "Refresh status cache",
-		},
-	})
+	c := &Impl{
+		pluginAPI: pluginAPI,
+	}
+	c.subcommands = c.newSubcommands()
+
+	// Autocomplete data is generated from the subcommands
+	autocompleteData := model.NewAutocompleteData(spotifyCommandTrigger, "[command]", "Spotify integration")
+	for _, sub := range c.subcommands {
+		autocompleteData.AddCommand(sub.autocompleteData())
+	}
 
 	// Register command
 	err := pluginAPI.RegisterCommand(&model.Command{
 		Trigger:          spotifyCommandTrigger,
 		AutoComplete:     true,
 		AutoCompleteDesc: "Spotify integration",
-		AutoCompleteHint: "(enable|disable)",
+		AutoCompleteHint: "[command]",
 		AutocompleteData: autocompleteData,
 	})
 
-	return &Impl{
-		pluginAPI: pluginAPI,
-	}, err
+	return c, err
+}
+
+// newSubcommands returns the /spotify subcommands, in the order they are listed in help and autocomplete
+func (c *Impl) newSubcommands() []*subcommand {
+	return []*subcommand{
+		{
+			name:     "help",
+			helpText: "Show the available commands, or help for one command",
+			args:     []argument{{name: "command", description: "Command to show help for", optional: true}},
+			handler:  c.executeHelp,
+		},
+		{
+			name:     "enable",
+			helpText: "Enable Spotify integration",
+			args:     []argument{{name: "your@spotifyemail.com", description: "The email address of your Spotify account"}},
+			handler:  c.executeEnable,
+		},
+		{
+			name:     "disable",
+			helpText: "Disable Spotify integration",
+			handler:  c.executeDisable,
+		},
+		{
+			name:     "refresh",
+			helpText: "Refresh status cache",
+			handler:  c.executeRefresh,
+		},
+		{
+			name:     "share",
+			helpText: "Share what you're listening to in this channel",
+			args:     []argument{{name: "message", description: "Message to post with the track", optional: true, variadic: true}},
+			handler:  c.executeShare,
+		},
+		{
+			name:     "channel-playlist",
+			helpText: "Collect Spotify tracks posted in this channel into a playlist",
+			handler:  c.executeChannelPlaylist,
+			subcommands: []*subcommand{
+				{
+					name:     "link",
+					helpText: "Link a playlist you own to this channel",
+					args:     []argument{{name: "playlist URL", description: "Link to the Spotify playlist", dynamicURL: "/api/v1/autocomplete/playlists"}},
+					handler:  c.executeChannelPlaylistLink,
+				},
+				{
+					name:     "unlink",
+					helpText: "Unlink the playlist from this channel",
+					handler:  c.executeChannelPlaylistUnlink,
+				},
+			},
+		},
+		c.newPlaybackSubcommand("play", "Resume playback", nil),
+		c.newPlaybackSubcommand("pause", "Pause playback", nil),
+		c.newPlaybackSubcommand("next", "Skip to the next track", nil),
+		c.newPlaybackSubcommand("previous", "Go back to the previous track", nil),
+		c.newPlaybackSubcommand("volume", "Set the playback volume", &argument{name: "0-100", description: "Volume percentage"}),
+		c.newPlaybackSubcommand("shuffle", "Turn shuffle on or off", &argument{name: "mode", description: "Shuffle mode, toggled if not given", optional: true, values: []string{"on", "off"}}),
+		c.newPlaybackSubcommand("repeat", "Set the repeat mode", &argument{name: "mode", description: "Repeat mode, cycled if not given", optional: true, values: []string{"off", "track", "context"}}),
+		{
+			name:     "devices",
+			helpText: "List your Spotify Connect devices",
+			handler:  c.executeDevices,
+		},
+		{
+			name:     "device",
+			helpText: "Transfer playback to another device",
+			args:     []argument{{name: "name", description: "Device to transfer playback to", variadic: true, dynamicURL: "/api/v1/autocomplete/devices"}},
+			handler:  c.executeDevice,
+		},
+		{
+			name:     "search",
+			helpText: "Search Spotify for tracks, albums, playlists and artists",
+			args:     []argument{{name: "query", description: "What to search for", variadic: true}},
+			flags: []flag{
+				{name: "type", description: "Only search for one type of result", kind: flagString, values: []string{"track", "album", "playlist", "artist"}},
+				{name: "limit", description: "Number of results of each type, up to 10", kind: flagInt},
+			},
+			handler: c.executeSearch,
+		},
+		{
+			name:     "recommend",
+			helpText: "Recommend a track to a teammate",
+			args: []argument{
+				{name: "@user", description: "Who to recommend the track to", dynamicURL: "/api/v1/autocomplete/members"},
+				{name: "track URL|current", description: "Link to the track, or current for what you're playing (the default)", optional: true, accepts: c.isTrack, dynamicURL: "/api/v1/autocomplete/recent"},
+				{name: "note", description: "Note to send with the recommendation", optional: true, variadic: true},
+			},
+			handler: c.executeRecommend,
+		},
+		{
+			name:     "inbox",
+			helpText: "List your unread track recommendations",
+			handler:  c.executeInbox,
+		},
+		{
+			name:     "recommendations",
+			helpText: "Choose whether to receive track recommendations",
+			args:     []argument{{name: "choice", description: "Whether to accept or refuse recommendations", values: []string{"accept", "refuse"}}},
+			handler:  c.executeRecommendations,
+		},
+		{
+			name:     "history",
+			helpText: "List the tracks you've recently played",
+			args:     []argument{{name: "n", description: "Number of tracks to show", optional: true}},
+			handler:  c.executeHistory,
+		},
+		{
+			name:     "top",
+			helpText: "Show your top artists or tracks",
+			args: []argument{
+				{name: "type", description: "Whether to show artists or tracks (the default)", optional: true, values: []string{"artists", "tracks"}},
+				{name: "range", description: "Time range, medium by default", optional: true, values: []string{"short", "medium", "long"}},
+			},
+			handler: c.executeTop,
+		},
+		{
+			name:     "recent",
+			helpText: "List the tracks you or a teammate recently played",
+			args:     []argument{{name: "@user", description: "Teammate to list tracks for", optional: true, dynamicURL: "/api/v1/autocomplete/members"}},
+			handler:  c.executeRecent,
+		},
+		{
+			name:     "visibility",
+			helpText: "Choose who can see what you're listening to",
+			args:     []argument{{name: "who", description: "Who can see what you're listening to", values: []string{"everyone", "nobody"}}},
+			handler:  c.executeVisibility,
+		},
+		{
+			name:     "settings",
+			helpText: "Change your sharing, custom status and notification settings",
+			handler:  c.executeSettings,
+		},
+		{
+			name:     "who",
+			helpText: "List who in this channel is listening to Spotify",
+			handler:  c.executeWho,
+		},
+		{
+			name:     "status",
+			helpText: "Show what you or a teammate are listening to",
+			args:     []argument{{name: "@user", description: "Teammate to show the status of", optional: true, dynamicURL: "/api/v1/autocomplete/members"}},
+			handler:  c.executeStatus,
+		},
+		{
+			name:     "whoami",
+			helpText: "Show details of your Spotify connection",
+			handler:  c.executeWhoami,
+		},
+		{
+			name:       "admin",
+			helpText:   "Manage the users connected to Spotify",
+			permission: model.PermissionManageSystem,
+			subcommands: []*subcommand{
+				{
+					name:     "list",
+					helpText: "List connected users with their last fetch and any error",
+					handler:  c.executeAdminList,
+				},
+				{
+					name:     "disconnect",
+					helpText: "Disconnect a user's Spotify account and delete their data",
+					args:     []argument{{name: "@user", description: "User to disconnect", dynamicURL: "/api/v1/autocomplete/members"}},
+					handler:  c.executeAdminDisconnect,
+				},
+				{
+					name:     "refresh",
+					helpText: "Clear a user's status cache",
+					args:     []argument{{name: "@user", description: "User to refresh", dynamicURL: "/api/v1/autocomplete/members"}},
+					handler:  c.executeAdminRefresh,
+				},
+				{
+					name:     "stats",
+					helpText: "Show how many users are connected and active",
+					handler:  c.executeAdminStats,
+				},
+			},
+		},
+	}
+}
+
+// newPlaybackSubcommand returns a subcommand that controls the user's playback, optionally taking a value
+func (c *Impl) newPlaybackSubcommand(action, helpText string, value *argument) *subcommand {
+	sub := &subcommand{
+		name:     action,
+		helpText: helpText,
+		handler: func(args *model.CommandArgs, parts []string, _ flagValues) (*model.CommandResponse, error) {
+			return c.executePlayback(args, action, parts)
+		},
+	}
+	if value != nil {
+		sub.args = []argument{*value}
+	}
+	return sub
 }
 
 // Handle executes the commands that were registered in the NewCommandHandler function
@@ -62,73 +250,390 @@ func (c *Impl) Handle(args *model.CommandArgs) (*model.CommandResponse, error) {
 }
 
 func (c *Impl) executeSpotifyCommand(args *model.CommandArgs) (*model.CommandResponse, error) {
-	parts := strings.Fields(args.Command)
-	if len(parts) < 2 {
-		return &model.CommandResponse{
-			ResponseType: model.CommandResponseTypeEphemeral,
-			Text:         "Usage:\n  /spotify enable your@spotifyemail.com\n  /spotify disable\n  /spotify refresh",
-		}, nil
+	tokens, err := tokenize(args.Command)
+	if err != nil {
+		return ephemeralResponse(parseErrorMessage(err, args.Command)), nil
+	}
+	if len(tokens) < 2 {
+		return ephemeralResponse(c.help(args.UserId)), nil
 	}
 
-	switch parts[1] {
-	case "enable":
-		if len(parts) != 3 {
-			return &model.CommandResponse{
-				ResponseType: model.CommandResponseTypeEphemeral,
-				Text:         "Syntax: /spotify enable your@spotifyemail.com",
-			}, nil
+	sub := c.findSubcommand(tokens[1].value)
+	if sub == nil {
+		return ephemeralResponse(fmt.Sprintf("Unknown command: %s\n\n%s", tokens[1].value, c.help(args.UserId))), nil
+	}
+
+	return c.executeSubcommand(sub, "/"+spotifyCommandTrigger, args, tokens[2:])
+}
+
+// executeSubcommand runs a subcommand, or the nested subcommand named by its first argument, after checking the
+// user's permissions and parsing its flags and arguments
+func (c *Impl) executeSubcommand(sub *subcommand, path string, args *model.CommandArgs, tokens []token) (*model.CommandResponse, error) {
+	if sub.permission != nil && !c.pluginAPI.HasPermissionTo(args.UserId, sub.permission) {
+		return ephemeralResponse(fmt.Sprintf("You don't have permission to run `%s %s`.", path, sub.name)), nil
+	}
+
+	if len(tokens) > 0 {
+		if nested := sub.find(tokens[0].value); nested != nil {
+			return c.executeSubcommand(nested, path+" "+sub.name, args, tokens[1:])
 		}
-		email := parts[2]
+	}
+
+	if sub.handler == nil {
+		return ephemeralResponse(sub.detailedHelp(path)), nil
+	}
+
+	// Subcommands without flags take words starting with -- as they are, e.g. in a share message
+	positional, flags := tokens, flagValues{}
+	var err error
+	if len(sub.flags) > 0 {
+		positional, flags, err = parseFlags(tokens, sub.flags)
+	}
+	variadicStart := -1
+	if err == nil {
+		variadicStart, err = sub.checkArgs(positional, len(args.Command))
+	}
+	if err != nil {
+		return ephemeralResponse(syntaxErrorMessage(err, args.Command, sub.usage(path))), nil
+	}
 
-		if err := c.pluginAPI.StoreUserEmail(args.UserId, email); err != nil {
-			return &model.CommandResponse{
-				ResponseType: model.CommandResponseTypeEphemeral,
-				Text:         "Failed to store email: " + err.Error(),
-			}, nil
+	// A variadic argument is passed as a single part holding its words as they were typed
+	parts := tokenValues(positional)
+	if variadicStart >= 0 {
+		parts = append(parts[:variadicStart], freeText(args.Command, tokens, positional[variadicStart:]))
+	}
+
+	response, err := sub.handler(args, parts, flags)
+	var argErr *argumentError
+	if errors.As(err, &argErr) {
+		err = &parseError{pos: positional[argErr.index].pos, message: argErr.message}
+		return ephemeralResponse(syntaxErrorMessage(err, args.Command, sub.usage(path))), nil
+	}
+	return response, err
+}
+
+// syntaxErrorMessage describes an error in the arguments of a subcommand, followed by its syntax
+func syntaxErrorMessage(err error, command, usage string) string {
+	return parseErrorMessage(err, command) + "\nSyntax: `" + usage + "`"
+}
+
+// parseErrorMessage describes an error parsing a command, pointing at where in the command it occurred
+func parseErrorMessage(err error, command string) string {
+	var parseErr *parseError
+	if errors.As(err, &parseErr) {
+		return parseErr.format(command)
+	}
+	return err.Error()
+}
+
+// findSubcommand returns the top level subcommand with the given name, or nil if there is none
+func (c *Impl) findSubcommand(name string) *subcommand {
+	for _, sub := range c.subcommands {
+		if sub.name == name {
+			return sub
 		}
+	}
+	return nil
+}
 
-		url, err := c.pluginAPI.GetSpotifyAuthURL()
-		if err != nil {
-			return &model.CommandResponse{
-				ResponseType: model.CommandResponseTypeEphemeral,
-				Text:         "Failed to generate auth URL: " + err.Error(),
-			}, nil
+// help lists the subcommands the user has permission to run
+func (c *Impl) help(userID string) string {
+	var sb strings.Builder
+	sb.WriteString("#### Spotify commands\n")
+	for _, sub := range c.subcommands {
+		if sub.permission == nil || c.pluginAPI.HasPermissionTo(userID, sub.permission) {
+			sub.writeSummary(&sb, "/"+spotifyCommandTrigger)
 		}
+	}
+	return sb.String()
+}
 
-		return &model.CommandResponse{
-			ResponseType: model.CommandResponseTypeEphemeral,
-			GotoLocation: url,
-			Text:         "Complete the authorization process in the new window to authorize with Spotify!",
-		}, nil
+// ephemeralResponse returns a response only shown to the user who ran the command
+func ephemeralResponse(text string, attachments ...*model.SlackAttachment) *model.CommandResponse {
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         text,
+		Attachments:  attachments,
+	}
+}
+
+func (c *Impl) executeHelp(args *model.CommandArgs, parts []string, _ flagValues) (*model.CommandResponse, error) {
+	if len(parts) == 0 {
+		return ephemeralResponse(c.help(args.UserId)), nil
+	}
+
+	sub := c.findSubcommand(parts[0])
+	if sub == nil {
+		return ephemeralResponse(fmt.Sprintf("Unknown command: %s\n\n%s", parts[0], c.help(args.UserId))), nil
+	}
+	return ephemeralResponse(sub.detailedHelp("/" + spotifyCommandTrigger)), nil
+}
+
+func (c *Impl) executeEnable(args *model.CommandArgs, parts []string, _ flagValues) (*model.CommandResponse, error) {
+	if err := c.pluginAPI.StoreUserEmail(args.UserId, parts[0]); err != nil {
+		return ephemeralResponse("Failed to store email: " + err.Error()), nil
+	}
 
-	case "disable":
-		if err := c.pluginAPI.ClearUserData(args.UserId); err != nil {
-			return &model.CommandResponse{
-				ResponseType: model.CommandResponseTypeEphemeral,
-				Text:         "Failed to disable: " + err.Error(),
-			}, nil
+	url, err := c.pluginAPI.GetSpotifyAuthURL()
+	if err != nil {
+		return ephemeralResponse("Failed to generate auth URL: " + err.Error()), nil
+	}
+
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		GotoLocation: url,
+		Text:         "Complete the authorization process in the new window to authorize with Spotify!",
+	}, nil
+}
+
+func (c *Impl) executeDisable(args *model.CommandArgs, _ []string, _ flagValues) (*model.CommandResponse, error) {
+	if err := c.pluginAPI.ClearUserData(args.UserId); err != nil {
+		return ephemeralResponse("Failed to disable: " + err.Error()), nil
+	}
+	return ephemeralResponse("Disabled Spotify integration!"), nil
+}
+
+func (c *Impl) executeRefresh(args *model.CommandArgs, _ []string, _ flagValues) (*model.CommandResponse, error) {
+	if err := c.pluginAPI.ClearStatusCache(args.UserId); err != nil {
+		return ephemeralResponse("Failed to refresh status cache: " + err.Error()), nil
+	}
+	return ephemeralResponse("Status cache cleared!"), nil
+}
+
+func (c *Impl) executeShare(args *model.CommandArgs, parts []string, _ flagValues) (*model.CommandResponse, error) {
+	var message string
+	if len(parts) == 1 {
+		message = parts[0]
+	}
+
+	if err := c.pluginAPI.ShareCurrentTrack(args.UserId, args.ChannelId, args.RootId, message); err != nil {
+		return ephemeralResponse("Failed to share: " + err.Error()), nil
+	}
+	return &model.CommandResponse{}, nil
+}
+
+func (c *Impl) executeChannelPlaylist(args *model.CommandArgs, _ []string, _ flagValues) (*model.CommandResponse, error) {
+	text, err := c.pluginAPI.DescribeChannelPlaylist(args.ChannelId)
+	if err != nil {
+		text = "Failed to get channel playlist: " + err.Error()
+	}
+	return ephemeralResponse(text), nil
+}
+
+func (c *Impl) executeChannelPlaylistLink(args *model.CommandArgs, parts []string, _ flagValues) (*model.CommandResponse, error) {
+	text, err := c.pluginAPI.LinkChannelPlaylist(args.UserId, args.ChannelId, parts[0])
+	if err != nil {
+		text = "Failed to link playlist: " + err.Error()
+	}
+	return ephemeralResponse(text), nil
+}
+
+func (c *Impl) executeChannelPlaylistUnlink(args *model.CommandArgs, _ []string, _ flagValues) (*model.CommandResponse, error) {
+	if err := c.pluginAPI.UnlinkChannelPlaylist(args.UserId, args.ChannelId); err != nil {
+		return ephemeralResponse("Failed to unlink playlist: " + err.Error()), nil
+	}
+	return ephemeralResponse("Unlinked the playlist from this channel."), nil
+}
+
+func (c *Impl) executePlayback(args *model.CommandArgs, action string, parts []string) (*model.CommandResponse, error) {
+	var value string
+	if len(parts) == 1 {
+		value = parts[0]
+	}
+
+	return ephemeralResponse(c.pluginAPI.ControlPlayback(args.UserId, args.ChannelId, action, value)), nil
+}
+
+func (c *Impl) executeDevices(args *model.CommandArgs, _ []string, _ flagValues) (*model.CommandResponse, error) {
+	return ephemeralResponse(c.pluginAPI.ListDevices(args.UserId)), nil
+}
+
+func (c *Impl) executeDevice(args *model.CommandArgs, parts []string, _ flagValues) (*model.CommandResponse, error) {
+	return ephemeralResponse(c.pluginAPI.TransferPlayback(args.UserId, args.ChannelId, parts[0])), nil
+}
+
+func (c *Impl) executeSearch(args *model.CommandArgs, parts []string, flags flagValues) (*model.CommandResponse, error) {
+	var types []string
+	if itemType := flags.String("type"); itemType != "" {
+		types = []string{itemType}
+	}
+
+	text, attachments := c.pluginAPI.Search(args.UserId, parts[0], types, flags.Int("limit"))
+	return ephemeralResponse(text, attachments...), nil
+}
+
+func (c *Impl) executeRecommend(args *model.CommandArgs, parts []string, _ flagValues) (*model.CommandResponse, error) {
+	if !strings.HasPrefix(parts[0], "@") {
+		return nil, &argumentError{index: 0, message: fmt.Sprintf("Expected a user like @alice, not %q.", parts[0])}
+	}
+
+	// The track is optional and defaults to whatever the sender is playing
+	track := "current"
+	noteParts := parts[1:]
+	if len(noteParts) > 0 && c.isTrack(noteParts[0]) {
+		track = noteParts[0]
+		noteParts = noteParts[1:]
+	}
+
+	var note string
+	if len(noteParts) == 1 {
+		note = noteParts[0]
+	}
+
+	text, err := c.pluginAPI.Recommend(args.UserId, parts[0], track, note)
+	if err != nil {
+		text = "Failed to send recommendation: " + err.Error()
+	}
+	return ephemeralResponse(text), nil
+}
+
+// isTrack reports whether an argument names a track to recommend: current, or a Spotify track link, URI or ID
+func (c *Impl) isTrack(value string) bool {
+	return value == "current" || c.pluginAPI.IsSpotifyTrack(value)
+}
+
+func (c *Impl) executeInbox(args *model.CommandArgs, _ []string, _ flagValues) (*model.CommandResponse, error) {
+	text, attachments, err := c.pluginAPI.ListRecommendations(args.UserId)
+	if err != nil {
+		text = "Failed to get recommendations: " + err.Error()
+	}
+	return ephemeralResponse(text, attachments...), nil
+}
+
+func (c *Impl) executeRecommendations(args *model.CommandArgs, parts []string, _ flagValues) (*model.CommandResponse, error) {
+	accept := parts[0] == "accept"
+	if err := c.pluginAPI.SetAcceptRecommendations(args.UserId, accept); err != nil {
+		return ephemeralResponse("Failed to update setting: " + err.Error()), nil
+	}
+
+	text := "You will no longer receive track recommendations."
+	if accept {
+		text = "You will now receive track recommendations."
+	}
+	return ephemeralResponse(text), nil
+}
+
+func (c *Impl) executeHistory(args *model.CommandArgs, parts []string, _ flagValues) (*model.CommandResponse, error) {
+	limit := 0
+	if len(parts) == 1 {
+		var err error
+		if limit, err = strconv.Atoi(parts[0]); err != nil || limit <= 0 {
+			return nil, &argumentError{index: 0, message: fmt.Sprintf("Expected a number of tracks to show, not %q.", parts[0])}
 		}
-		return &model.CommandResponse{
-			ResponseType: model.CommandResponseTypeEphemeral,
-			Text:         "Disabled Spotify integration!",
-		}, nil
+	}
 
-	case "refresh":
-		if err := c.pluginAPI.ClearStatusCache(args.UserId); err != nil {
-			return &model.CommandResponse{
-				ResponseType: model.CommandResponseTypeEphemeral,
-				Text:         "Failed to refresh status cache: " + err.Error(),
-			}, nil
+	text, err := c.pluginAPI.History(args.UserId, limit)
+	if err != nil {
+		text = "Failed to get history: " + err.Error()
+	}
+	return ephemeralResponse(text), nil
+}
+
+func (c *Impl) executeTop(args *model.CommandArgs, parts []string, _ flagValues) (*model.CommandResponse, error) {
+	// Both arguments are optional, so the range may be given without the type
+	itemType, timeRange := "tracks", "medium"
+	for _, part := range parts {
+		switch part {
+		case "artists", "tracks":
+			itemType = part
+		default:
+			timeRange = part
 		}
-		return &model.CommandResponse{
-			ResponseType: model.CommandResponseTypeEphemeral,
-			Text:         "Status cache cleared!",
-		}, nil
+	}
 
-	default:
-		return &model.CommandResponse{
-			ResponseType: model.CommandResponseTypeEphemeral,
-			Text:         "Usage:\n  /spotify enable your@spotifyemail.com\n  /spotify disable\n  /spotify refresh",
-		}, nil
+	text, attachments := c.pluginAPI.Top(args.UserId, itemType, timeRange)
+	return ephemeralResponse(text, attachments...), nil
+}
+
+func (c *Impl) executeRecent(args *model.CommandArgs, parts []string, _ flagValues) (*model.CommandResponse, error) {
+	var targetUsername string
+	if len(parts) == 1 {
+		targetUsername = parts[0]
+	}
+
+	text, err := c.pluginAPI.Recent(args.UserId, targetUsername)
+	if err != nil {
+		text = "Failed to get recently played tracks: " + err.Error()
+	}
+	return ephemeralResponse(text), nil
+}
+
+func (c *Impl) executeVisibility(args *model.CommandArgs, parts []string, _ flagValues) (*model.CommandResponse, error) {
+	if err := c.pluginAPI.SetVisibility(args.UserId, parts[0]); err != nil {
+		return ephemeralResponse("Failed to update setting: " + err.Error()), nil
+	}
+
+	text := "Everyone can now see what you're listening to."
+	if parts[0] == "nobody" {
+		text = "What you're listening to is now hidden from everyone else."
+	}
+	return ephemeralResponse(text), nil
+}
+
+func (c *Impl) executeSettings(args *model.CommandArgs, _ []string, _ flagValues) (*model.CommandResponse, error) {
+	if err := c.pluginAPI.OpenSettingsDialog(args.UserId, args.TriggerId); err != nil {
+		return ephemeralResponse("Failed to open settings: " + err.Error()), nil
+	}
+	return &model.CommandResponse{}, nil
+}
+
+func (c *Impl) executeWho(args *model.CommandArgs, _ []string, _ flagValues) (*model.CommandResponse, error) {
+	text, err := c.pluginAPI.WhoIsListening(args.UserId, args.ChannelId)
+	if err != nil {
+		text = "Failed to get who's listening: " + err.Error()
+	}
+	return ephemeralResponse(text), nil
+}
+
+func (c *Impl) executeStatus(args *model.CommandArgs, parts []string, _ flagValues) (*model.CommandResponse, error) {
+	var targetUsername string
+	if len(parts) == 1 {
+		targetUsername = parts[0]
+	}
+
+	text, attachments, err := c.pluginAPI.UserStatus(args.UserId, targetUsername)
+	if err != nil {
+		text = "Failed to get status: " + err.Error()
+	}
+	return ephemeralResponse(text, attachments...), nil
+}
+
+func (c *Impl) executeWhoami(args *model.CommandArgs, _ []string, _ flagValues) (*model.CommandResponse, error) {
+	text, err := c.pluginAPI.Whoami(args.UserId)
+	if err != nil {
+		text = "Failed to get connection details: " + err.Error()
+	}
+	return ephemeralResponse(text), nil
+}
+
+func (c *Impl) executeAdminList(_ *model.CommandArgs, _ []string, _ flagValues) (*model.CommandResponse, error) {
+	text, err := c.pluginAPI.AdminListUsers()
+	if err != nil {
+		return ephemeralResponse("Failed to list users: " + err.Error()), nil
+	}
+	return ephemeralResponse(text), nil
+}
+
+func (c *Impl) executeAdminDisconnect(args *model.CommandArgs, parts []string, _ flagValues) (*model.CommandResponse, error) {
+	text, err := c.pluginAPI.AdminDisconnectUser(args.UserId, parts[0])
+	if err != nil {
+		return ephemeralResponse("Failed to disconnect user: " + err.Error()), nil
+	}
+	return ephemeralResponse(text), nil
+}
+
+func (c *Impl) executeAdminRefresh(_ *model.CommandArgs, parts []string, _ flagValues) (*model.CommandResponse, error) {
+	text, err := c.pluginAPI.AdminRefreshUser(parts[0])
+	if err != nil {
+		return ephemeralResponse("Failed to refresh user: " + err.Error()), nil
+	}
+	return ephemeralResponse(text), nil
+}
+
+func (c *Impl) executeAdminStats(_ *model.CommandArgs, _ []string, _ flagValues) (*model.CommandResponse, error) {
+	text, err := c.pluginAPI.AdminStats()
+	if err != nil {
+		return ephemeralResponse("Failed to get stats: " + err.Error()), nil
 	}
+	return ephemeralResponse(text), nil
 }
@@ -0,0 +1,170 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestExecuteSubcommandFreeText(t *testing.T) {
+	for name, tc := range map[string]struct {
+		command       string
+		sub           *subcommand
+		expectedParts []string
+	}{
+		"apostrophes in a share message": {
+			command:       "/spotify share it's so good",
+			sub:           &subcommand{name: "share", args: []argument{{name: "message", optional: true, variadic: true}}},
+			expectedParts: []string{"it's so good"},
+		},
+		"apostrophes in a recommendation note": {
+			command: "/spotify recommend @bob current you'll love this",
+			sub: &subcommand{name: "recommend", args: []argument{
+				{name: "@user"},
+				{name: "track", optional: true},
+				{name: "note", optional: true, variadic: true},
+			}},
+			expectedParts: []string{"@bob", "current", "you'll love this"},
+		},
+		"dashes in a share message": {
+			command:       "/spotify share best -- song --ever",
+			sub:           &subcommand{name: "share", args: []argument{{name: "message", optional: true, variadic: true}}},
+			expectedParts: []string{"best -- song --ever"},
+		},
+		"quotes, spaces and lines in a share message": {
+			command:       "/spotify share \"the\" best  song\nof the year ",
+			sub:           &subcommand{name: "share", args: []argument{{name: "message", optional: true, variadic: true}}},
+			expectedParts: []string{"\"the\" best  song\nof the year"},
+		},
+		"quoted device name": {
+			command:       "/spotify device \"Living Room Speaker\"",
+			sub:           &subcommand{name: "device", args: []argument{{name: "name", variadic: true}}},
+			expectedParts: []string{"Living Room Speaker"},
+		},
+		"flags after a search query": {
+			command: "/spotify search never  gonna --limit 3",
+			sub: &subcommand{
+				name:  "search",
+				args:  []argument{{name: "query", variadic: true}},
+				flags: []flag{{name: "limit", kind: flagInt}},
+			},
+			expectedParts: []string{"never  gonna"},
+		},
+		"flags among a search query": {
+			command: "/spotify search never --limit 3 gonna",
+			sub: &subcommand{
+				name:  "search",
+				args:  []argument{{name: "query", variadic: true}},
+				flags: []flag{{name: "limit", kind: flagInt}},
+			},
+			expectedParts: []string{"never gonna"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var gotParts []string
+			tc.sub.handler = func(_ *model.CommandArgs, parts []string, _ flagValues) (*model.CommandResponse, error) {
+				gotParts = parts
+				return &model.CommandResponse{}, nil
+			}
+
+			tokens, err := tokenize(tc.command)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			c := &Impl{}
+			response, err := c.executeSubcommand(tc.sub, "/spotify", &model.CommandArgs{Command: tc.command}, tokens[2:])
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if gotParts == nil {
+				t.Fatalf("handler wasn't run, got response %q", response.Text)
+			}
+
+			if len(gotParts) != len(tc.expectedParts) {
+				t.Fatalf("expected parts %q, got %q", tc.expectedParts, gotParts)
+			}
+			for i := range gotParts {
+				if gotParts[i] != tc.expectedParts[i] {
+					t.Errorf("expected parts %q, got %q", tc.expectedParts, gotParts)
+				}
+			}
+		})
+	}
+}
+
+// fakePluginAPI records the recommendations sent through it. Methods it doesn't override panic if called.
+type fakePluginAPI struct {
+	PluginAPI
+	track string
+	note  string
+}
+
+func (f *fakePluginAPI) IsSpotifyTrack(value string) bool {
+	return strings.HasPrefix(value, "https://open.spotify.com/track/")
+}
+
+func (f *fakePluginAPI) Recommend(_, _, track, note string) (string, error) {
+	f.track, f.note = track, note
+	return "", nil
+}
+
+func TestExecuteRecommendTrack(t *testing.T) {
+	for name, tc := range map[string]struct {
+		command       string
+		expectedTrack string
+		expectedNote  string
+	}{
+		"no track": {
+			command:       "/spotify recommend @bob",
+			expectedTrack: "current",
+		},
+		"current track with a note": {
+			command:       "/spotify recommend @bob current so good",
+			expectedTrack: "current",
+			expectedNote:  "so good",
+		},
+		"track link with a note": {
+			command:       "/spotify recommend @bob https://open.spotify.com/track/4cOdK2wGLETKBW3PvgPWqT so good",
+			expectedTrack: "https://open.spotify.com/track/4cOdK2wGLETKBW3PvgPWqT",
+			expectedNote:  "so good",
+		},
+		"note starting with spotify": {
+			command:       "/spotify recommend @bob spotify's best ever",
+			expectedTrack: "current",
+			expectedNote:  "spotify's best ever",
+		},
+		"note over several lines": {
+			command:       "/spotify recommend @bob current so good\n\"really\"",
+			expectedTrack: "current",
+			expectedNote:  "so good\n\"really\"",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			pluginAPI := &fakePluginAPI{}
+			c := &Impl{pluginAPI: pluginAPI}
+			c.subcommands = c.newSubcommands()
+
+			tokens, err := tokenize(tc.command)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			response, err := c.executeSubcommand(c.findSubcommand("recommend"), "/spotify", &model.CommandArgs{Command: tc.command}, tokens[2:])
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if response.Text != "" {
+				t.Fatalf("unexpected response %q", response.Text)
+			}
+
+			if pluginAPI.track != tc.expectedTrack {
+				t.Errorf("expected track %q, got %q", tc.expectedTrack, pluginAPI.track)
+			}
+			if pluginAPI.note != tc.expectedNote {
+				t.Errorf("expected note %q, got %q", tc.expectedNote, pluginAPI.note)
+			}
+		})
+	}
+}
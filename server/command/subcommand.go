@@ -0,0 +1,230 @@
+package command
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// subcommand describes a /spotify subcommand. The autocomplete tree and help text are generated from these
+// descriptions, and arguments are checked against them before the handler runs.
+type subcommand struct {
+	name     string
+	helpText string
+	args     []argument
+	flags    []flag
+	// permission is required to run the subcommand, or nil if anyone can
+	permission *model.Permission
+	// handler runs the subcommand with its arguments. Subcommands with nested subcommands may leave it nil to show
+	// help when none of them is given.
+	handler     func(args *model.CommandArgs, parts []string, flags flagValues) (*model.CommandResponse, error)
+	subcommands []*subcommand
+}
+
+// argument describes an argument of a subcommand
+type argument struct {
+	name        string
+	description string
+	optional    bool
+	// variadic arguments take the rest of the command, and must be last
+	variadic bool
+	// values lists the accepted values, which are offered as autocomplete suggestions. An optional argument with
+	// values is skipped when given a value it doesn't accept, so a later argument can be given without it.
+	values []string
+	// accepts reports whether an optional argument accepts a value it isn't restricted to by values, and is skipped
+	// like one with values when it doesn't
+	accepts func(value string) bool
+	// dynamicURL is the plugin route serving autocomplete suggestions for the argument
+	dynamicURL string
+}
+
+// skips reports whether the argument is left out when given a value, as it is optional and doesn't accept the value
+func (a argument) skips(value string) bool {
+	if !a.optional {
+		return false
+	}
+	if a.accepts != nil {
+		return !a.accepts(value)
+	}
+	return len(a.values) > 0 && !slices.Contains(a.values, value)
+}
+
+// argumentError is returned by a subcommand's handler when one of its arguments is invalid, and is reported like a
+// parse error pointing at the argument
+type argumentError struct {
+	// index is the position of the invalid argument in the handler's parts
+	index   int
+	message string
+}
+
+func (e *argumentError) Error() string {
+	return e.message
+}
+
+// hint returns how the argument is shown in usage text, e.g. <query> or [on|off]
+func (a argument) hint() string {
+	name := a.name
+	if len(a.values) > 0 {
+		name = strings.Join(a.values, "|")
+	}
+	if a.variadic {
+		name += "..."
+	}
+	if a.optional {
+		return "[" + name + "]"
+	}
+	return "<" + name + ">"
+}
+
+// hint returns how the subcommand's arguments are shown in usage text
+func (s *subcommand) hint() string {
+	if len(s.args) == 0 && len(s.subcommands) > 0 {
+		names := make([]string, 0, len(s.subcommands))
+		for _, sub := range s.subcommands {
+			names = append(names, sub.name)
+		}
+		return "[" + strings.Join(names, "|") + "]"
+	}
+
+	hints := make([]string, 0, len(s.args)+len(s.flags))
+	for _, arg := range s.args {
+		hints = append(hints, arg.hint())
+	}
+	for _, f := range s.flags {
+		hints = append(hints, f.hint())
+	}
+	return strings.Join(hints, " ")
+}
+
+// usage returns the full syntax of the subcommand, given the path of command names leading to it
+func (s *subcommand) usage(path string) string {
+	return strings.TrimSpace(path + " " + s.name + " " + s.hint())
+}
+
+// find returns the nested subcommand with the given name, or nil if there is none
+func (s *subcommand) find(name string) *subcommand {
+	for _, sub := range s.subcommands {
+		if sub.name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// checkArgs checks the given arguments satisfy the subcommand's declared arguments and values, returning an error
+// pointing at the first extra or invalid argument, or at the end of the command if arguments are missing. It also
+// returns the index of the first argument given to a variadic argument, or -1 if there is none.
+func (s *subcommand) checkArgs(tokens []token, commandLength int) (int, error) {
+	next := 0
+	variadicStart := -1
+	for i, tok := range tokens {
+		for next < len(s.args)-1 && s.args[next].skips(tok.value) {
+			next++
+		}
+		if next >= len(s.args) {
+			return -1, &parseError{pos: tok.pos, message: fmt.Sprintf("Unexpected argument %q.", tok.value)}
+		}
+
+		arg := s.args[next]
+		if len(arg.values) > 0 && !slices.Contains(arg.values, tok.value) {
+			return -1, &parseError{pos: tok.pos, message: fmt.Sprintf("Expected one of %s, not %q.", strings.Join(arg.values, ", "), tok.value)}
+		}
+		next++
+		if arg.variadic {
+			variadicStart = i
+			break
+		}
+	}
+
+	for _, arg := range s.args[next:] {
+		if !arg.optional {
+			return -1, &parseError{pos: commandLength, message: fmt.Sprintf("Missing %s.", arg.hint())}
+		}
+	}
+	return variadicStart, nil
+}
+
+// autocompleteData generates the autocomplete tree for the subcommand
+func (s *subcommand) autocompleteData() *model.AutocompleteData {
+	data := model.NewAutocompleteData(s.name, s.hint(), s.helpText)
+	if s.permission == model.PermissionManageSystem {
+		data.RoleID = model.SystemAdminRoleId
+	}
+
+	// Autocomplete entries can't have both nested commands and arguments
+	if len(s.subcommands) > 0 {
+		for _, sub := range s.subcommands {
+			data.AddCommand(sub.autocompleteData())
+		}
+		return data
+	}
+
+	for _, arg := range s.args {
+		switch {
+		case arg.dynamicURL != "":
+			data.AddDynamicListArgument(arg.description, arg.dynamicURL, !arg.optional)
+		case len(arg.values) > 0:
+			items := make([]model.AutocompleteListItem, 0, len(arg.values))
+			for _, value := range arg.values {
+				items = append(items, model.AutocompleteListItem{Item: value})
+			}
+			data.AddStaticListArgument(arg.description, !arg.optional, items)
+		default:
+			data.AddTextArgument(arg.description, arg.hint(), "")
+		}
+	}
+
+	for _, f := range s.flags {
+		if len(f.values) > 0 {
+			items := make([]model.AutocompleteListItem, 0, len(f.values))
+			for _, value := range f.values {
+				items = append(items, model.AutocompleteListItem{Item: value})
+			}
+			data.AddNamedStaticListArgument(f.name, f.description, false, items)
+			continue
+		}
+		data.AddNamedTextArgument(f.name, f.description, strings.TrimSuffix(strings.TrimPrefix(f.hint(), "[--"+f.name), "]"), "", false)
+	}
+	return data
+}
+
+// writeSummary writes a line of help for the subcommand and each of its nested subcommands
+func (s *subcommand) writeSummary(sb *strings.Builder, path string) {
+	if s.handler != nil || len(s.subcommands) == 0 {
+		fmt.Fprintf(sb, "- `%s` - %s\n", s.usage(path), s.helpText)
+	}
+	for _, sub := range s.subcommands {
+		sub.writeSummary(sb, path+" "+s.name)
+	}
+}
+
+// detailedHelp returns the full help for the subcommand, describing its arguments and nested subcommands
+func (s *subcommand) detailedHelp(path string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "`%s`\n%s\n", s.usage(path), s.helpText)
+
+	if len(s.args) > 0 {
+		sb.WriteString("\n**Arguments:**\n")
+		for _, arg := range s.args {
+			fmt.Fprintf(&sb, "- `%s` - %s\n", arg.hint(), arg.description)
+		}
+	}
+
+	if len(s.flags) > 0 {
+		sb.WriteString("\n**Flags:**\n")
+		for _, f := range s.flags {
+			fmt.Fprintf(&sb, "- `%s` - %s\n", strings.Trim(f.hint(), "[]"), f.description)
+		}
+	}
+
+	if len(s.subcommands) > 0 {
+		sb.WriteString("\n**Subcommands:**\n")
+		for _, sub := range s.subcommands {
+			sub.writeSummary(&sb, path+" "+s.name)
+		}
+	}
+
+	return sb.String()
+}
@@ -1,28 +1,23 @@
 package main
 
 import (
+	"context"
+	"net/url"
 	"reflect"
 
 	"github.com/pkg/errors"
+	"github.com/zmb3/spotify/v2"
 	spotifyauth "github.com/zmb3/spotify/v2/auth"
+	"golang.org/x/oauth2/clientcredentials"
 )
 
-// Configuration captures the plugin's external Configuration as exposed in the Mattermost server
-// Configuration, as well as values computed from the Configuration. Any public fields will be
-// deserialized from the Mattermost server Configuration in OnConfigurationChange.
+// The Configuration struct is generated from the settings_schema in plugin.json into configuration_gen.go, so a
+// setting is only declared once. Add settings to plugin.json and run make apply.
 //
 // As plugins are inherently concurrent (hooks being called asynchronously), and the plugin
 // Configuration can change at any time, access to the Configuration must be synchronized. The
 // strategy used in this plugin is to guard a pointer to the Configuration, and clone the entire
-// struct whenever it changes. You may replace this with whatever strategy you choose.
-//
-// If you add non-reference types to your Configuration struct, be sure to rewrite Clone as a deep
-// copy appropriate for your types.
-type Configuration struct {
-	ClientID                   string
-	ClientSecret               string
-	StatusCacheDurationMinutes int
-}
+// struct whenever it changes.
 
 // Clone shallow copies the configuration. Your implementation may require a deep copy if
 // your configuration has reference types.
@@ -31,6 +26,20 @@ func (c *Configuration) Clone() *Configuration {
 	return &clone
 }
 
+// getConfiguration retrieves the active configuration under lock, making it safe to use
+// concurrently. The active configuration may change underneath the client of this method, but
+// the struct returned by this API call is considered immutable.
+func (p *Plugin) getConfiguration() *Configuration {
+	p.configurationLock.RLock()
+	defer p.configurationLock.RUnlock()
+
+	if p.configuration == nil {
+		return &Configuration{}
+	}
+
+	return p.configuration
+}
+
 // setConfiguration replaces the active configuration under lock.
 //
 // Do not call setConfiguration while holding the configurationLock, as sync.Mutex is not
@@ -55,23 +64,95 @@ func (p *Plugin) setConfiguration(configuration *Configuration) {
 		panic("setConfiguration called with the existing configuration")
 	}
 
-	// Initialize Spotify authenticator if configuration is provided
-	if configuration != nil && configuration.ClientID != "" && configuration.ClientSecret != "" {
-		siteURL := *p.API.GetConfig().ServiceSettings.SiteURL
-		callbackURL := siteURL + "/plugins/com.clearstargroup.cs-mattermost-spotify-plugin/callback"
-		p.auth = spotifyauth.New(
-			spotifyauth.WithRedirectURL(callbackURL),
-			spotifyauth.WithScopes(
-				spotifyauth.ScopeUserReadPrivate,
-				spotifyauth.ScopeUserReadEmail,
-				spotifyauth.ScopeUserReadPlaybackState,
-			),
-			spotifyauth.WithClientID(configuration.ClientID),
-			spotifyauth.WithClientSecret(configuration.ClientSecret),
-		)
+	p.configuration = configuration
+}
+
+// IsValid checks the configuration, describing the first problem found. Spotify credentials may be left unset
+// until an admin has created a Spotify application, but a site URL is needed to build the OAuth redirect URL once
+// they are set.
+func (c *Configuration) IsValid(siteURL string) error {
+	if (c.ClientID == "") != (c.ClientSecret == "") {
+		return errors.New("ClientID and ClientSecret must either both be set or both be empty")
 	}
+	if c.ClientID != "" {
+		if problem := credentialFormatProblem(c.ClientID); problem != "" {
+			return errors.Errorf("ClientID must be %d lowercase hexadecimal characters, but it %s", spotifyCredentialLength, problem)
+		}
+		if problem := credentialFormatProblem(c.ClientSecret); problem != "" {
+			return errors.Errorf("ClientSecret must be %d lowercase hexadecimal characters, but it %s", spotifyCredentialLength, problem)
+		}
 
-	p.configuration = configuration
+		if siteURL == "" {
+			return errors.New("the server's Site URL must be set to build the Spotify redirect URL")
+		}
+		if parsed, err := url.Parse(siteURL); err != nil || parsed.Host == "" {
+			return errors.Errorf("the server's Site URL %q is not a valid absolute URL", siteURL)
+		}
+	}
+
+	if err := c.checkSchema(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// spotifyCredentials are the settings the Spotify authenticator and app client are built from
+type spotifyCredentials struct {
+	clientID     string
+	clientSecret string
+	redirectURL  string
+}
+
+// getAuth returns the Spotify authenticator under lock, or nil if Spotify isn't configured. The authenticator may be
+// replaced when the configuration changes, so callers should fetch it once and use the same one throughout.
+func (p *Plugin) getAuth() *spotifyauth.Authenticator {
+	p.configurationLock.RLock()
+	defer p.configurationLock.RUnlock()
+
+	return p.auth
+}
+
+// getAppClient returns the Spotify client authorized as the application under lock, or nil if Spotify isn't
+// configured. Like the authenticator, callers should fetch it once and use the same one throughout.
+func (p *Plugin) getAppClient() *spotify.Client {
+	p.configurationLock.RLock()
+	defer p.configurationLock.RUnlock()
+
+	return p.appClient
+}
+
+// setSpotifyClients builds the Spotify authenticator and app client from the credentials under lock, or tears them
+// down when the credentials are unset. They are left alone if the credentials haven't changed.
+func (p *Plugin) setSpotifyClients(credentials spotifyCredentials) {
+	p.configurationLock.Lock()
+	defer p.configurationLock.Unlock()
+
+	if credentials == p.spotifyCredentials {
+		return
+	}
+	p.spotifyCredentials = credentials
+
+	if credentials.clientID == "" {
+		p.auth = nil
+		p.appClient = nil
+		return
+	}
+
+	p.auth = spotifyauth.New(
+		spotifyauth.WithRedirectURL(credentials.redirectURL),
+		spotifyauth.WithScopes(baseScopes...),
+		spotifyauth.WithClientID(credentials.clientID),
+		spotifyauth.WithClientSecret(credentials.clientSecret),
+	)
+
+	// App client authorized with the client credentials grant, for requests not made on behalf of a user
+	appConfig := &clientcredentials.Config{
+		ClientID:     credentials.clientID,
+		ClientSecret: credentials.clientSecret,
+		TokenURL:     spotifyauth.TokenURL,
+	}
+	p.appClient = spotify.New(appConfig.Client(context.Background()))
 }
 
 // MatterMost plugin hook - invoked when configuration changes may have been made or on plugin activation
@@ -83,8 +164,27 @@ func (p *Plugin) OnConfigurationChange() error {
 		return errors.Wrap(err, "failed to load plugin configuration")
 	}
 
+	siteURL := ""
+	if config := p.API.GetConfig(); config != nil && config.ServiceSettings.SiteURL != nil {
+		siteURL = *config.ServiceSettings.SiteURL
+	}
+	if err := configuration.IsValid(siteURL); err != nil {
+		// Rejected settings leave the previous ones in effect, with nothing to show for it in the System Console
+		p.API.LogError("The saved plugin settings were rejected and not applied, so the previous settings are still in effect. Test Connection in the plugin settings describes the problem.", "error", err.Error())
+		return errors.Wrap(err, "invalid plugin configuration")
+	}
+
 	p.setConfiguration(configuration)
 
+	// Rebuild the Spotify clients whenever the credentials or the site URL the redirect URL is built from change
+	credentials := spotifyCredentials{clientID: configuration.ClientID, clientSecret: configuration.ClientSecret}
+	if credentials.clientID != "" {
+		credentials.redirectURL = p.callbackURL()
+	} else {
+		p.API.LogWarn("Spotify ClientID and ClientSecret are not set, so users can't connect their Spotify accounts")
+	}
+	p.setSpotifyClients(credentials)
+
 	return nil
 }
 
@@ -94,7 +194,31 @@ func (p *Plugin) GetStatusCacheDurationMinutes() int {
 	defer p.configurationLock.RUnlock()
 
 	if p.configuration.StatusCacheDurationMinutes <= 0 {
-		return 15 // Default to 15 minutes
+		return defaultStatusCacheDurationMinutes
 	}
 	return p.configuration.StatusCacheDurationMinutes
 }
+
+// getChannelPlaylistMaxTracks returns the maximum number of tracks collected into a channel playlist
+func (p *Plugin) getChannelPlaylistMaxTracks() int {
+	if maxTracks := p.getConfiguration().ChannelPlaylistMaxTracks; maxTracks > 0 {
+		return maxTracks
+	}
+	return defaultChannelPlaylistMaxTracks
+}
+
+// getHistoryRetentionDays returns how many days of listening history are kept for each user
+func (p *Plugin) getHistoryRetentionDays() int {
+	if days := p.getConfiguration().HistoryRetentionDays; days > 0 {
+		return days
+	}
+	return defaultHistoryRetentionDays
+}
+
+// getLastListenedMaxAgeMinutes returns how long after playback stops the last listened track is still shown
+func (p *Plugin) getLastListenedMaxAgeMinutes() int {
+	if minutes := p.getConfiguration().LastListenedMaxAgeMinutes; minutes > 0 {
+		return minutes
+	}
+	return defaultLastListenedMaxAgeMinutes
+}
@@ -0,0 +1,50 @@
+// This file is automatically generated from the settings_schema in plugin.json. Do not modify it manually;
+// change plugin.json and run make apply instead.
+
+package main
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Configuration captures the plugin's external configuration as exposed in the Mattermost server configuration.
+// Its fields are deserialized from the Mattermost server configuration in OnConfigurationChange.
+type Configuration struct {
+	// Client ID from your Spotify Application
+	ClientID string
+	// Client Secret from your Spotify Application
+	ClientSecret string
+	// The duration in minutes that a users listening status will be cached for
+	StatusCacheDurationMinutes int
+	// The maximum number of tracks collected into a playlist linked to a channel with /spotify channel-playlist link
+	ChannelPlaylistMaxTracks int
+	// The number of days of listening history kept for each user, shown with /spotify history
+	HistoryRetentionDays int
+	// When a user isn't playing, what they last listened to is shown if it was within this many minutes
+	LastListenedMaxAgeMinutes int
+}
+
+// The defaults of the settings, as declared in plugin.json
+const (
+	defaultStatusCacheDurationMinutes = 15
+	defaultChannelPlaylistMaxTracks   = 500
+	defaultHistoryRetentionDays       = 365
+	defaultLastListenedMaxAgeMinutes  = 1440
+)
+
+// checkSchema checks the configured values are allowed by the settings schema
+func (c *Configuration) checkSchema() error {
+	if c.StatusCacheDurationMinutes < 0 {
+		return errors.Errorf("StatusCacheDurationMinutes must not be negative, got %d", c.StatusCacheDurationMinutes)
+	}
+	if c.ChannelPlaylistMaxTracks < 0 {
+		return errors.Errorf("ChannelPlaylistMaxTracks must not be negative, got %d", c.ChannelPlaylistMaxTracks)
+	}
+	if c.HistoryRetentionDays < 0 {
+		return errors.Errorf("HistoryRetentionDays must not be negative, got %d", c.HistoryRetentionDays)
+	}
+	if c.LastListenedMaxAgeMinutes < 0 {
+		return errors.Errorf("LastListenedMaxAgeMinutes must not be negative, got %d", c.LastListenedMaxAgeMinutes)
+	}
+	return nil
+}
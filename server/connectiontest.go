@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	spotifyauth "github.com/zmb3/spotify/v2/auth"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// connectionTestTimeout limits how long the connection test waits for Spotify's token endpoint
+const connectionTestTimeout = 10 * time.Second
+
+// spotifyCredentialLength is the length of Spotify client IDs and secrets
+const spotifyCredentialLength = 32
+
+// credentialFormatProblem describes what is wrong with a Spotify client ID or secret that doesn't look like one, e.g.
+// "is 31 characters long", or returns an empty string if it looks right
+func credentialFormatProblem(value string) string {
+	var problems []string
+	if length := utf8.RuneCountInString(value); length != spotifyCredentialLength {
+		problems = append(problems, fmt.Sprintf("is %d characters long", length))
+	}
+	for _, r := range value {
+		switch {
+		case r >= '0' && r <= '9', r >= 'a' && r <= 'f':
+			continue
+		case unicode.IsUpper(r):
+			problems = append(problems, fmt.Sprintf("contains the uppercase letter %q", r))
+		default:
+			problems = append(problems, fmt.Sprintf("contains %q, which isn't a hexadecimal character", r))
+		}
+		break
+	}
+	return strings.Join(problems, " and ")
+}
+
+// connectionCheck is the outcome of one check made by the connection test
+type connectionCheck struct {
+	Name    string
+	OK      bool
+	Message string
+}
+
+// connectionTestResult is the outcome of testing the plugin's Spotify configuration
+type connectionTestResult struct {
+	OK     bool
+	Checks []connectionCheck
+}
+
+// callbackURL returns the OAuth redirect URL Spotify sends users back to after they authorize the plugin
+func (p *Plugin) callbackURL() string {
+	return p.pluginURL() + "/callback"
+}
+
+// testConnection checks the saved Spotify credentials against Spotify's token endpoint and that the redirect URL
+// Spotify will be given is well-formed, describing exactly what is wrong with each. The saved settings are tested
+// rather than the active ones, as settings rejected when saved leave the previous settings in effect.
+func (p *Plugin) testConnection(ctx context.Context) *connectionTestResult {
+	siteURL := ""
+	if config := p.API.GetConfig(); config != nil && config.ServiceSettings.SiteURL != nil {
+		siteURL = *config.ServiceSettings.SiteURL
+	}
+
+	configuration := new(Configuration)
+	settingsCheck := connectionCheck{Name: "Saved settings"}
+	if err := p.API.LoadPluginConfiguration(configuration); err != nil {
+		settingsCheck.Message = fmt.Sprintf("The saved settings couldn't be loaded: %s.", err)
+	} else if err := configuration.IsValid(siteURL); err != nil {
+		settingsCheck.Message = fmt.Sprintf("The saved settings were rejected, so the previous settings are still in effect: %s.", err)
+	} else {
+		settingsCheck.OK = true
+		settingsCheck.Message = "The saved settings are valid."
+	}
+
+	clientIDCheck := checkCredentialFormat("Client ID", configuration.ClientID)
+	clientSecretCheck := checkCredentialFormat("Client Secret", configuration.ClientSecret)
+	checks := []connectionCheck{settingsCheck, clientIDCheck, clientSecretCheck}
+
+	if clientIDCheck.OK && clientSecretCheck.OK {
+		checks = append(checks, checkClientCredentials(ctx, configuration.ClientID, configuration.ClientSecret))
+	} else {
+		checks = append(checks, connectionCheck{Name: "Spotify token endpoint", Message: "Not checked until the Client ID and Client Secret are fixed."})
+	}
+
+	checks = append(checks, checkRedirectURL(siteURL, p.callbackURL()))
+
+	result := &connectionTestResult{OK: true, Checks: checks}
+	for _, check := range checks {
+		if !check.OK {
+			result.OK = false
+		}
+	}
+	return result
+}
+
+// checkCredentialFormat checks a Spotify client ID or secret is set and looks like one
+func checkCredentialFormat(name, value string) connectionCheck {
+	check := connectionCheck{Name: name}
+	problem := credentialFormatProblem(value)
+	switch {
+	case value == "":
+		check.Message = fmt.Sprintf("%s isn't set. Copy it from your app in the Spotify Developer Dashboard.", name)
+	case problem != "":
+		check.Message = fmt.Sprintf("%s should be %d lowercase hexadecimal characters, but %s. Check it was copied in full, without extra spaces.", name, spotifyCredentialLength, problem)
+	default:
+		check.OK = true
+		check.Message = fmt.Sprintf("%s is set.", name)
+	}
+	return check
+}
+
+// checkClientCredentials requests a token from Spotify's token endpoint with the client credentials grant
+func checkClientCredentials(ctx context.Context, clientID, clientSecret string) connectionCheck {
+	check := connectionCheck{Name: "Spotify token endpoint"}
+
+	ctx, cancel := context.WithTimeout(ctx, connectionTestTimeout)
+	defer cancel()
+
+	config := &clientcredentials.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		TokenURL:     spotifyauth.TokenURL,
+	}
+	if _, err := config.Token(ctx); err != nil {
+		var retrieveErr *oauth2.RetrieveError
+		switch {
+		case errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_client":
+			check.Message = "Spotify rejected the Client ID and Client Secret: " + retrieveErr.ErrorDescription + ". Check they match your app in the Spotify Developer Dashboard."
+		case errors.As(err, &retrieveErr):
+			check.Message = fmt.Sprintf("Spotify's token endpoint returned %s: %s", retrieveErr.Response.Status, string(retrieveErr.Body))
+		default:
+			check.Message = "Couldn't reach Spotify's token endpoint: " + err.Error()
+		}
+		return check
+	}
+
+	check.OK = true
+	check.Message = "Spotify accepted the Client ID and Client Secret."
+	return check
+}
+
+// checkRedirectURL checks the redirect URL built from the site URL is one Spotify will accept
+func checkRedirectURL(siteURL, redirectURL string) connectionCheck {
+	check := connectionCheck{Name: "Redirect URL"}
+	if siteURL == "" {
+		check.Message = "Site URL isn't set, so the redirect URL can't be built. Set it in System Console > Environment > Web Server."
+		return check
+	}
+
+	parsed, err := url.Parse(redirectURL)
+	switch {
+	case err != nil:
+		check.Message = fmt.Sprintf("The redirect URL %s isn't a valid URL: %s. Check the Site URL.", redirectURL, err)
+	case parsed.Host == "":
+		check.Message = fmt.Sprintf("The redirect URL %s has no host. Check the Site URL includes one, e.g. https://chat.example.com.", redirectURL)
+	case parsed.Scheme != "https" && !(parsed.Scheme == "http" && isLoopbackHost(parsed.Hostname())):
+		check.Message = fmt.Sprintf("The redirect URL %s must use https, which Spotify requires for hosts other than a loopback address. Check the Site URL.", redirectURL)
+	default:
+		check.OK = true
+		check.Message = fmt.Sprintf("Make sure %s is added as a Redirect URI in your app's settings in the Spotify Developer Dashboard.", redirectURL)
+	}
+	return check
+}
+
+// isLoopbackHost reports whether a host is a loopback IP address
+func isLoopbackHost(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// handleConnectionTest tests the plugin's Spotify configuration, for system admins
+func (p *Plugin) handleConnectionTest(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if !p.API.HasPermissionTo(userID, model.PermissionManageSystem) {
+		http.Error(w, "only system admins can test the connection", http.StatusForbidden)
+		return
+	}
+
+	result := p.testConnection(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		p.API.LogError("Failed to encode response", "error", err)
+	}
+}
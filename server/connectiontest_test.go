@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+// fakeConfigAPI serves the saved plugin settings and the server's Site URL. Methods it doesn't override panic if
+// called.
+type fakeConfigAPI struct {
+	plugin.API
+	saved   Configuration
+	siteURL string
+}
+
+func (f *fakeConfigAPI) LoadPluginConfiguration(dest any) error {
+	*dest.(*Configuration) = f.saved
+	return nil
+}
+
+func (f *fakeConfigAPI) GetConfig() *model.Config {
+	config := &model.Config{}
+	config.ServiceSettings.SiteURL = model.NewPointer(f.siteURL)
+	return config
+}
+
+func TestTestConnectionChecksSavedSettings(t *testing.T) {
+	for name, tc := range map[string]struct {
+		saved         Configuration
+		expectedCheck string
+	}{
+		"client secret missing": {
+			saved:         Configuration{ClientID: "0123456789abcdef0123456789abcdef"},
+			expectedCheck: "Saved settings",
+		},
+		"client ID with uppercase letters": {
+			saved:         Configuration{ClientID: "0123456789ABCDEF0123456789abcdef", ClientSecret: "0123456789abcdef0123456789abcdef"},
+			expectedCheck: "Client ID",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := &Plugin{}
+			p.API = &fakeConfigAPI{saved: tc.saved, siteURL: "https://chat.example.com"}
+
+			// The previous, valid settings are still in effect as the saved ones were rejected
+			p.setConfiguration(&Configuration{ClientID: "fedcba9876543210fedcba9876543210", ClientSecret: "fedcba9876543210fedcba9876543210"})
+
+			// Spotify's token endpoint isn't reached, as the saved credentials are invalid
+			result := p.testConnection(context.Background())
+			if result.OK {
+				t.Fatal("expected the connection test to fail")
+			}
+			for _, check := range result.Checks {
+				if check.Name == "Saved settings" && check.OK {
+					t.Errorf("expected the saved settings check to fail, got %q", check.Message)
+				}
+				if check.Name == tc.expectedCheck && check.OK {
+					t.Errorf("expected the %s check to fail, got %q", check.Name, check.Message)
+				}
+				if check.Name == "Spotify token endpoint" && check.OK {
+					t.Errorf("expected the token endpoint not to be checked, got %q", check.Message)
+				}
+			}
+		})
+	}
+}
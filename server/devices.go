@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// Command Plugin API - lists the user's available Spotify Connect devices
+func (p *Plugin) ListDevices(userID string) string {
+	return p.runAsUser(userID, nil, func(ctx context.Context, client *spotify.Client) (string, error) {
+		devices, err := client.PlayerDevices(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		if len(devices) == 0 {
+			return "No Spotify devices are available. Open Spotify on one of your devices and try again.", nil
+		}
+
+		var sb strings.Builder
+		sb.WriteString("| Device | Type | Volume |\n|:--|:--|--:|\n")
+		for _, device := range devices {
+			name := device.Name
+			if device.Active {
+				name = "**" + name + "** (active)"
+			}
+			fmt.Fprintf(&sb, "| %s | %s | %d%% |\n", name, device.Type, int(device.Volume))
+		}
+		sb.WriteString("\nMove playback with `/spotify device <name>`.")
+
+		return sb.String(), nil
+	})
+}
+
+// Command Plugin API - transfers playback to the named device, then sends the user a card showing what's now playing
+func (p *Plugin) TransferPlayback(userID, channelID, deviceName string) string {
+	succeeded := false
+	text := p.runAsUser(userID, playbackScopes, func(ctx context.Context, client *spotify.Client) (string, error) {
+		devices, err := client.PlayerDevices(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		device := findDevice(devices, deviceName)
+		if device == nil {
+			return fmt.Sprintf("No device named **%s** found. Run `/spotify devices` to see your available devices.", deviceName), nil
+		}
+
+		if err := client.TransferPlayback(ctx, device.ID, true); err != nil {
+			return "", err
+		}
+
+		succeeded = true
+		return fmt.Sprintf("Moved playback to **%s**.", device.Name), nil
+	})
+
+	if succeeded {
+		go p.sendNowPlaying(userID, channelID)
+	}
+	return text
+}
+
+// findDevice finds a device by name, preferring an exact match over a unique prefix match (ignoring case)
+func findDevice(devices []spotify.PlayerDevice, name string) *spotify.PlayerDevice {
+	var prefixMatch *spotify.PlayerDevice
+	prefixMatches := 0
+	for i := range devices {
+		deviceName := strings.ToLower(devices[i].Name)
+		if deviceName == strings.ToLower(name) {
+			return &devices[i]
+		}
+		if strings.HasPrefix(deviceName, strings.ToLower(name)) {
+			prefixMatch = &devices[i]
+			prefixMatches++
+		}
+	}
+
+	if prefixMatches == 1 {
+		return prefixMatch
+	}
+	return nil
+}
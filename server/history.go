@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clearstargroup/cs-mattermost-spotify-plugin/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	"github.com/zmb3/spotify/v2"
+)
+
+const (
+	defaultHistoryLength = 10
+	maxHistoryLength     = 50
+	// historyMinOverlapMs is the window within which two plays of the same track are treated as one when their durations are unknown
+	historyMinOverlapMs = 30 * 1000
+)
+
+// historyMonth returns the monthly history bucket a play belongs to
+func historyMonth(playedAt int64) string {
+	return time.UnixMilli(playedAt).UTC().Format("2006-01")
+}
+
+// isSamePlay reports whether two history entries describe the same play of a track, i.e. they overlap in time
+func isSamePlay(a, b *kvstore.HistoryEntry) bool {
+	if a.TrackID != b.TrackID {
+		return false
+	}
+
+	return b.PlayedAt < a.PlayedAt+int64(max(a.DurationMs, historyMinOverlapMs)) &&
+		a.PlayedAt < b.PlayedAt+int64(max(b.DurationMs, historyMinOverlapMs))
+}
+
+// recordPlay adds the track in a player state to the user's listening history, unless it is already recorded
+func (p *Plugin) recordPlay(userID string, state *spotify.PlayerState) error {
+	track := state.Item
+	if track == nil || track.ID == "" {
+		return nil
+	}
+
+	// The play started however far into the track playback has progressed
+	entry := &kvstore.HistoryEntry{
+		TrackID:      string(track.ID),
+		TrackName:    track.Name,
+		TrackArtists: joinArtistNames(track.Artists),
+		ArtistNames:  artistNames(track.Artists),
+		AlbumName:    track.Album.Name,
+		TrackURL:     track.ExternalURLs["spotify"],
+		PlayedAt:     time.Now().UnixMilli() - int64(state.Progress),
+		DurationMs:   int(track.Duration),
+	}
+
+	_, _, err := p.addHistoryEntries(userID, []*kvstore.HistoryEntry{entry})
+	return err
+}
+
+// addHistoryEntries adds plays to a user's listening history, skipping those already recorded or outside the
+// retention window, and returns how many were added and how many were outside the retention window
+func (p *Plugin) addHistoryEntries(userID string, entries []*kvstore.HistoryEntry) (int, int, error) {
+	unlock, err := p.lock("history-lock-" + userID)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer unlock()
+
+	cutoff := p.getHistoryCutoff()
+	entries, outsideRetention := splitHistoryEntries(entries, cutoff)
+
+	// Load every month a play could already be recorded in, including the neighbouring month for plays near the
+	// start or end of a month
+	history := make(map[string][]*kvstore.HistoryEntry)
+	for _, entry := range entries {
+		for _, month := range historyMonthsAround(entry) {
+			if _, ok := history[month]; ok {
+				continue
+			}
+			existing, err := p.kvstore.GetHistory(userID, month)
+			if err != nil {
+				return 0, outsideRetention, err
+			}
+			history[month] = existing
+		}
+	}
+
+	changedMonths, added := mergeHistoryEntries(history, entries)
+	for _, month := range changedMonths {
+		if err := p.kvstore.StoreHistory(userID, month, history[month]); err != nil {
+			return 0, outsideRetention, err
+		}
+	}
+
+	if err := p.pruneHistory(userID, cutoff); err != nil {
+		p.API.LogError("Failed to prune history", "userID", userID, "error", err)
+	}
+
+	return added, outsideRetention, nil
+}
+
+// splitHistoryEntries returns the plays at or after the retention cutoff, and how many plays were before it
+func splitHistoryEntries(entries []*kvstore.HistoryEntry, cutoff int64) ([]*kvstore.HistoryEntry, int) {
+	kept := make([]*kvstore.HistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.PlayedAt >= cutoff {
+			kept = append(kept, entry)
+		}
+	}
+	return kept, len(entries) - len(kept)
+}
+
+// historyMonthsAround returns the monthly history buckets a play could already be recorded in: its own month, and
+// the neighbouring month when the play is close enough to the start or end of its month to overlap it
+func historyMonthsAround(entry *kvstore.HistoryEntry) []string {
+	window := int64(max(entry.DurationMs, historyMinOverlapMs))
+	var months []string
+	for _, playedAt := range []int64{entry.PlayedAt - window, entry.PlayedAt, entry.PlayedAt + window} {
+		if month := historyMonth(playedAt); !slices.Contains(months, month) {
+			months = append(months, month)
+		}
+	}
+	return months
+}
+
+// mergeHistoryEntries adds plays to the monthly history buckets holding historyMonthsAround each of them, skipping
+// plays already recorded in any of those buckets. It returns the months that changed, which are kept newest first,
+// and how many plays were added.
+func mergeHistoryEntries(history map[string][]*kvstore.HistoryEntry, entries []*kvstore.HistoryEntry) ([]string, int) {
+	var changedMonths []string
+	added := 0
+	for _, entry := range entries {
+		recorded := false
+		for _, month := range historyMonthsAround(entry) {
+			if slices.ContainsFunc(history[month], func(e *kvstore.HistoryEntry) bool { return isSamePlay(e, entry) }) {
+				recorded = true
+				break
+			}
+		}
+		if recorded {
+			continue
+		}
+
+		month := historyMonth(entry.PlayedAt)
+		history[month] = append(history[month], entry)
+		if !slices.Contains(changedMonths, month) {
+			changedMonths = append(changedMonths, month)
+		}
+		added++
+	}
+
+	for _, month := range changedMonths {
+		sort.SliceStable(history[month], func(i, j int) bool { return history[month][i].PlayedAt > history[month][j].PlayedAt })
+	}
+	return changedMonths, added
+}
+
+// pruneHistory deletes the months of a user's history that are entirely before the retention cutoff
+func (p *Plugin) pruneHistory(userID string, cutoff int64) error {
+	months, err := p.kvstore.ListHistoryMonths(userID)
+	if err != nil {
+		return err
+	}
+
+	cutoffMonth := historyMonth(cutoff)
+	for _, month := range months {
+		if month < cutoffMonth {
+			if err := p.kvstore.DeleteHistory(userID, month); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// getHistory returns up to limit of a user's most recent plays since a time, newest first. A limit of zero returns
+// every play since then; plays before the retention window are never returned.
+func (p *Plugin) getHistory(userID string, since int64, limit int) ([]*kvstore.HistoryEntry, error) {
+	months, err := p.kvstore.ListHistoryMonths(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	since = max(since, p.getHistoryCutoff())
+	sinceMonth := historyMonth(since)
+	var history []*kvstore.HistoryEntry
+	for _, month := range months {
+		if month < sinceMonth {
+			break
+		}
+
+		entries, err := p.kvstore.GetHistory(userID, month)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if entry.PlayedAt < since {
+				return history, nil
+			}
+			history = append(history, entry)
+			if limit > 0 && len(history) >= limit {
+				return history, nil
+			}
+		}
+	}
+
+	return history, nil
+}
+
+// Command Plugin API - lists a user's most recent plays from their recorded listening history
+func (p *Plugin) History(userID string, limit int) (string, error) {
+	if limit <= 0 {
+		limit = defaultHistoryLength
+	}
+
+	history, err := p.getHistory(userID, 0, min(limit, maxHistoryLength))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get history")
+	}
+
+	if len(history) == 0 {
+		return "No listening history recorded yet. Plays are recorded whenever the plugin fetches your Spotify status.", nil
+	}
+
+	return "#### Your listening history\n" + formatHistory(history, time.Now()), nil
+}
+
+// formatHistory renders history entries as a markdown list with relative timestamps
+func formatHistory(history []*kvstore.HistoryEntry, now time.Time) string {
+	var sb strings.Builder
+	for _, entry := range history {
+		name := entry.TrackName
+		if entry.TrackURL != "" {
+			name = fmt.Sprintf("[%s](%s)", entry.TrackName, entry.TrackURL)
+		}
+		fmt.Fprintf(&sb, "- **%s** by %s, %s\n", name, entry.TrackArtists, formatTimeAgo(time.UnixMilli(entry.PlayedAt), now))
+	}
+	return sb.String()
+}
+
+// formatTimeAgo describes how long before now a time was, e.g. "25 minutes ago"
+func formatTimeAgo(t, now time.Time) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s ago", unit)
+		}
+		return fmt.Sprintf("%d %ss ago", n, unit)
+	}
+
+	elapsed := now.Sub(t)
+	switch {
+	case elapsed < time.Minute:
+		return "just now"
+	case elapsed < time.Hour:
+		return plural(int(elapsed.Minutes()), "minute")
+	case elapsed < 24*time.Hour:
+		return plural(int(elapsed.Hours()), "hour")
+	default:
+		return plural(int(elapsed.Hours()/24), "day")
+	}
+}
+
+// handleHistory returns the requesting user's recorded listening history
+func (p *Plugin) handleHistory(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+
+	limit := defaultHistoryLength
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	history, err := p.getHistory(userID, 0, min(limit, maxHistoryLength))
+	if err != nil {
+		p.API.LogError("Failed to get history", "userID", userID, "error", err)
+		http.Error(w, "failed to get history", http.StatusInternalServerError)
+		return
+	}
+	if history == nil {
+		history = []*kvstore.HistoryEntry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(history); err != nil {
+		p.API.LogError("Failed to encode response", "error", err)
+	}
+}
+
+// getHistoryCutoff returns the start of the history retention window, in milliseconds since the epoch
+func (p *Plugin) getHistoryCutoff() int64 {
+	return model.GetMillisForTime(time.Now().AddDate(0, 0, -p.getHistoryRetentionDays()))
+}
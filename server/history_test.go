@@ -0,0 +1,166 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/clearstargroup/cs-mattermost-spotify-plugin/server/store/kvstore"
+)
+
+func TestMergeHistoryEntries(t *testing.T) {
+	at := func(value string) int64 {
+		playedAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatalf("invalid time %q: %v", value, err)
+		}
+		return playedAt.UnixMilli()
+	}
+	threeMinutes := int(3 * time.Minute / time.Millisecond)
+
+	for name, tc := range map[string]struct {
+		history        map[string][]*kvstore.HistoryEntry
+		entries        []*kvstore.HistoryEntry
+		expectedAdded  int
+		expectedMonths []string
+		// expectedHistory lists the track IDs in each changed month, newest first
+		expectedHistory map[string][]string
+	}{
+		"new plays are added newest first": {
+			history: map[string][]*kvstore.HistoryEntry{
+				"2024-03": {{TrackID: "a", PlayedAt: at("2024-03-10T12:00:00Z"), DurationMs: threeMinutes}},
+			},
+			entries: []*kvstore.HistoryEntry{
+				{TrackID: "b", PlayedAt: at("2024-03-10T11:00:00Z"), DurationMs: threeMinutes},
+				{TrackID: "c", PlayedAt: at("2024-03-10T13:00:00Z"), DurationMs: threeMinutes},
+			},
+			expectedAdded:   2,
+			expectedMonths:  []string{"2024-03"},
+			expectedHistory: map[string][]string{"2024-03": {"c", "a", "b"}},
+		},
+		"duplicate play": {
+			history: map[string][]*kvstore.HistoryEntry{
+				"2024-03": {{TrackID: "a", PlayedAt: at("2024-03-10T12:00:00Z"), DurationMs: threeMinutes}},
+			},
+			entries: []*kvstore.HistoryEntry{
+				{TrackID: "a", PlayedAt: at("2024-03-10T12:00:05Z"), DurationMs: threeMinutes},
+			},
+			expectedAdded: 0,
+		},
+		"duplicate plays in the same import": {
+			history: map[string][]*kvstore.HistoryEntry{"2024-03": nil},
+			entries: []*kvstore.HistoryEntry{
+				{TrackID: "a", PlayedAt: at("2024-03-10T12:00:00Z"), DurationMs: threeMinutes},
+				{TrackID: "a", PlayedAt: at("2024-03-10T12:01:00Z"), DurationMs: threeMinutes},
+			},
+			expectedAdded:   1,
+			expectedMonths:  []string{"2024-03"},
+			expectedHistory: map[string][]string{"2024-03": {"a"}},
+		},
+		"replay of the same track after it ended": {
+			history: map[string][]*kvstore.HistoryEntry{
+				"2024-03": {{TrackID: "a", PlayedAt: at("2024-03-10T12:00:00Z"), DurationMs: threeMinutes}},
+			},
+			entries: []*kvstore.HistoryEntry{
+				{TrackID: "a", PlayedAt: at("2024-03-10T12:03:00Z"), DurationMs: threeMinutes},
+			},
+			expectedAdded:   1,
+			expectedMonths:  []string{"2024-03"},
+			expectedHistory: map[string][]string{"2024-03": {"a", "a"}},
+		},
+		"duplicate play across a month boundary": {
+			history: map[string][]*kvstore.HistoryEntry{
+				"2024-01": {{TrackID: "a", PlayedAt: at("2024-01-31T23:59:50Z"), DurationMs: threeMinutes}},
+				"2024-02": nil,
+			},
+			entries: []*kvstore.HistoryEntry{
+				{TrackID: "a", PlayedAt: at("2024-02-01T00:00:10Z"), DurationMs: threeMinutes},
+			},
+			expectedAdded: 0,
+		},
+		"new play across a month boundary": {
+			history: map[string][]*kvstore.HistoryEntry{
+				"2024-01": {{TrackID: "a", PlayedAt: at("2024-01-31T23:59:50Z"), DurationMs: threeMinutes}},
+				"2024-02": nil,
+			},
+			entries: []*kvstore.HistoryEntry{
+				{TrackID: "b", PlayedAt: at("2024-02-01T00:00:10Z"), DurationMs: threeMinutes},
+			},
+			expectedAdded:   1,
+			expectedMonths:  []string{"2024-02"},
+			expectedHistory: map[string][]string{"2024-02": {"b"}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			months, added := mergeHistoryEntries(tc.history, tc.entries)
+			if added != tc.expectedAdded {
+				t.Errorf("expected %d plays added, got %d", tc.expectedAdded, added)
+			}
+			if !slices.Equal(months, tc.expectedMonths) {
+				t.Errorf("expected changed months %q, got %q", tc.expectedMonths, months)
+			}
+			for month, expectedTrackIDs := range tc.expectedHistory {
+				var trackIDs []string
+				for _, entry := range tc.history[month] {
+					trackIDs = append(trackIDs, entry.TrackID)
+				}
+				if !slices.Equal(trackIDs, expectedTrackIDs) {
+					t.Errorf("expected %s to hold %q, got %q", month, expectedTrackIDs, trackIDs)
+				}
+			}
+		})
+	}
+}
+
+func TestHistoryMonthsAround(t *testing.T) {
+	for name, tc := range map[string]struct {
+		playedAt       string
+		expectedMonths []string
+	}{
+		"middle of a month": {
+			playedAt:       "2024-03-15T12:00:00Z",
+			expectedMonths: []string{"2024-03"},
+		},
+		"end of a month": {
+			playedAt:       "2024-03-31T23:59:00Z",
+			expectedMonths: []string{"2024-03", "2024-04"},
+		},
+		"start of a month": {
+			playedAt:       "2024-04-01T00:01:00Z",
+			expectedMonths: []string{"2024-03", "2024-04"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			playedAt, err := time.Parse(time.RFC3339, tc.playedAt)
+			if err != nil {
+				t.Fatalf("invalid time %q: %v", tc.playedAt, err)
+			}
+
+			months := historyMonthsAround(&kvstore.HistoryEntry{PlayedAt: playedAt.UnixMilli(), DurationMs: 3 * 60 * 1000})
+			if !slices.Equal(months, tc.expectedMonths) {
+				t.Errorf("expected months %q, got %q", tc.expectedMonths, months)
+			}
+		})
+	}
+}
+
+func TestSplitHistoryEntries(t *testing.T) {
+	cutoff := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	entries := []*kvstore.HistoryEntry{
+		{TrackID: "before", PlayedAt: cutoff - 1},
+		{TrackID: "at", PlayedAt: cutoff},
+		{TrackID: "after", PlayedAt: cutoff + 1},
+	}
+
+	kept, outsideRetention := splitHistoryEntries(entries, cutoff)
+	if outsideRetention != 1 {
+		t.Errorf("expected 1 play outside retention, got %d", outsideRetention)
+	}
+	var trackIDs []string
+	for _, entry := range kept {
+		trackIDs = append(trackIDs, entry.TrackID)
+	}
+	if expected := []string{"at", "after"}; !slices.Equal(trackIDs, expected) {
+		t.Errorf("expected plays %q to be kept, got %q", expected, trackIDs)
+	}
+}
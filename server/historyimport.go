@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/clearstargroup/cs-mattermost-spotify-plugin/server/store/kvstore"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxHistoryImportBytes limits the size of an uploaded streaming history export
+	maxHistoryImportBytes = 100 << 20
+	// minImportedPlayMs skips plays shorter than Spotify's own threshold for counting a stream
+	minImportedPlayMs = 30 * 1000
+)
+
+// streamingHistoryRecord is a single play in a Spotify "extended streaming history" export file
+type streamingHistoryRecord struct {
+	Timestamp   string `json:"ts"`
+	MsPlayed    int    `json:"ms_played"`
+	TrackName   string `json:"master_metadata_track_name"`
+	ArtistName  string `json:"master_metadata_album_artist_name"`
+	AlbumName   string `json:"master_metadata_album_album_name"`
+	TrackURI    string `json:"spotify_track_uri"`
+	EpisodeName string `json:"episode_name"`
+}
+
+// historyImportResult reports the outcome of an import
+type historyImportResult struct {
+	Records  int `json:"records"`
+	Imported int `json:"imported"`
+	// Skipped counts plays already recorded, short plays, podcast episodes and malformed records
+	Skipped int `json:"skipped"`
+	// OutsideRetention counts plays older than the history retention window, which aren't kept
+	OutsideRetention int    `json:"outside_retention"`
+	RetentionDays    int    `json:"retention_days"`
+	Message          string `json:"message,omitempty"`
+}
+
+// parseStreamingHistory converts the track plays in an extended streaming history export into history entries,
+// skipping podcast episodes, very short plays and malformed records
+func parseStreamingHistory(r io.Reader) ([]*kvstore.HistoryEntry, int, error) {
+	var records []streamingHistoryRecord
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return nil, 0, errors.Wrap(err, "not a Spotify extended streaming history file")
+	}
+
+	entries := make([]*kvstore.HistoryEntry, 0, len(records))
+	for _, record := range records {
+		trackID, ok := strings.CutPrefix(record.TrackURI, "spotify:track:")
+		if !ok || trackID == "" || record.MsPlayed < minImportedPlayMs {
+			continue
+		}
+
+		// Timestamps record when the play ended
+		endedAt, err := time.Parse(time.RFC3339, record.Timestamp)
+		if err != nil {
+			continue
+		}
+
+		entries = append(entries, &kvstore.HistoryEntry{
+			TrackID:      trackID,
+			TrackName:    record.TrackName,
+			TrackArtists: record.ArtistName,
+			ArtistNames:  []string{record.ArtistName},
+			AlbumName:    record.AlbumName,
+			TrackURL:     "https://open.spotify.com/track/" + trackID,
+			PlayedAt:     endedAt.UnixMilli() - int64(record.MsPlayed),
+			DurationMs:   record.MsPlayed,
+		})
+	}
+
+	return entries, len(records), nil
+}
+
+// handleHistoryImport imports one or more extended streaming history files into the requesting user's listening
+// history. Files are uploaded as multipart form "files" fields, or a single file as the raw request body.
+func (p *Plugin) handleHistoryImport(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	r.Body = http.MaxBytesReader(w, r.Body, maxHistoryImportBytes)
+
+	var readers []io.Reader
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			p.API.LogError("Failed to parse history upload", "userID", userID, "error", err)
+			http.Error(w, "failed to parse upload", http.StatusBadRequest)
+			return
+		}
+
+		for _, header := range r.MultipartForm.File["files"] {
+			file, err := header.Open()
+			if err != nil {
+				p.API.LogError("Failed to open uploaded file", "userID", userID, "file", header.Filename, "error", err)
+				http.Error(w, "failed to open "+header.Filename, http.StatusBadRequest)
+				return
+			}
+			defer file.Close()
+			readers = append(readers, file)
+		}
+	} else {
+		readers = append(readers, r.Body)
+	}
+
+	if len(readers) == 0 {
+		http.Error(w, "no files uploaded", http.StatusBadRequest)
+		return
+	}
+
+	var result historyImportResult
+	var entries []*kvstore.HistoryEntry
+	for _, reader := range readers {
+		fileEntries, records, err := parseStreamingHistory(reader)
+		if err != nil {
+			p.API.LogError("Failed to parse streaming history", "userID", userID, "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		entries = append(entries, fileEntries...)
+		result.Records += records
+	}
+
+	imported, outsideRetention, err := p.addHistoryEntries(userID, entries)
+	if err != nil {
+		p.API.LogError("Failed to import history", "userID", userID, "error", err)
+		http.Error(w, "failed to import history", http.StatusInternalServerError)
+		return
+	}
+	result.Imported = imported
+	result.OutsideRetention = outsideRetention
+	result.Skipped = result.Records - imported - outsideRetention
+	result.RetentionDays = p.getHistoryRetentionDays()
+	if outsideRetention > 0 {
+		result.Message = fmt.Sprintf("%d plays are older than the %d day history retention window and weren't imported. Ask a system admin to raise the History Retention Days setting, then import the files again.", outsideRetention, result.RetentionDays)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		p.API.LogError("Failed to encode response", "error", err)
+	}
+
+	p.API.LogInfo("Successfully imported streaming history", "userID", userID, "records", result.Records, "imported", imported, "outsideRetention", outsideRetention)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStreamingHistory(t *testing.T) {
+	for name, tc := range map[string]struct {
+		file            string
+		expectedRecords int
+		expectedTracks  []string
+		expectedErr     bool
+	}{
+		"track play": {
+			file:            `[{"ts": "2024-03-10T12:03:00Z", "ms_played": 180000, "master_metadata_track_name": "Song", "master_metadata_album_artist_name": "Artist", "master_metadata_album_album_name": "Album", "spotify_track_uri": "spotify:track:4cOdK2wGLETKBW3PvgPWqT"}]`,
+			expectedRecords: 1,
+			expectedTracks:  []string{"4cOdK2wGLETKBW3PvgPWqT"},
+		},
+		"skipped records": {
+			file: `[
+				{"ts": "2024-03-10T12:03:00Z", "ms_played": 10000, "spotify_track_uri": "spotify:track:short"},
+				{"ts": "2024-03-10T12:03:00Z", "ms_played": 180000, "episode_name": "Episode", "spotify_episode_uri": "spotify:episode:abc"},
+				{"ts": "yesterday", "ms_played": 180000, "spotify_track_uri": "spotify:track:badtime"},
+				{"ts": "2024-03-10T12:03:00Z", "ms_played": 180000, "spotify_track_uri": "spotify:track:"},
+				{"ts": "2024-03-10T12:06:00Z", "ms_played": 180000, "spotify_track_uri": "spotify:track:kept"}
+			]`,
+			expectedRecords: 5,
+			expectedTracks:  []string{"kept"},
+		},
+		"not a streaming history file": {
+			file:        `{"ts": "2024-03-10T12:03:00Z"}`,
+			expectedErr: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			entries, records, err := parseStreamingHistory(strings.NewReader(tc.file))
+			if tc.expectedErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if records != tc.expectedRecords {
+				t.Errorf("expected %d records, got %d", tc.expectedRecords, records)
+			}
+			if len(entries) != len(tc.expectedTracks) {
+				t.Fatalf("expected %d entries, got %d", len(tc.expectedTracks), len(entries))
+			}
+			for i, entry := range entries {
+				if entry.TrackID != tc.expectedTracks[i] {
+					t.Errorf("expected track %q, got %q", tc.expectedTracks[i], entry.TrackID)
+				}
+			}
+		})
+	}
+}
+
+func TestParseStreamingHistoryPlayedAt(t *testing.T) {
+	file := `[{"ts": "2024-03-01T00:02:00Z", "ms_played": 180000, "master_metadata_track_name": "Song", "master_metadata_album_artist_name": "Artist", "spotify_track_uri": "spotify:track:4cOdK2wGLETKBW3PvgPWqT"}]`
+
+	entries, _, err := parseStreamingHistory(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+
+	// Timestamps record when the play ended, so a play ending just after midnight started in the previous month
+	entry := entries[0]
+	if expected := time.Date(2024, 2, 29, 23, 59, 0, 0, time.UTC).UnixMilli(); entry.PlayedAt != expected {
+		t.Errorf("expected the play to start at %d, got %d", expected, entry.PlayedAt)
+	}
+	if month := historyMonth(entry.PlayedAt); month != "2024-02" {
+		t.Errorf("expected the play in 2024-02, got %s", month)
+	}
+	if entry.DurationMs != 180000 || entry.TrackArtists != "Artist" || len(entry.ArtistNames) != 1 {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+}
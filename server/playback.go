@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/zmb3/spotify/v2"
+	spotifyauth "github.com/zmb3/spotify/v2/auth"
+)
+
+// playbackSettleDelay gives Spotify time to apply a playback change before the new state is read back
+const playbackSettleDelay = 500 * time.Millisecond
+
+// playbackScopes are the OAuth scopes needed to control a user's playback
+var playbackScopes = []string{spotifyauth.ScopeUserModifyPlaybackState}
+
+// Command Plugin API - controls playback on the user's active device. Once Spotify has applied the change, a card
+// showing what's now playing is sent to the user in the channel.
+func (p *Plugin) ControlPlayback(userID, channelID, action, value string) string {
+	changed := false
+	text := p.runAsUser(userID, playbackScopes, func(ctx context.Context, client *spotify.Client) (string, error) {
+		message, ok, err := controlPlayback(ctx, client, action, value)
+		changed = ok && err == nil
+		return message, err
+	})
+
+	if changed {
+		go p.sendNowPlaying(userID, channelID)
+	}
+	return text
+}
+
+// sendNowPlaying reads back a user's playback state after a change, sending them a card for the track now playing.
+// It waits for Spotify to apply the change, so is run in the background rather than delaying the command response.
+func (p *Plugin) sendNowPlaying(userID, channelID string) {
+	time.Sleep(playbackSettleDelay)
+	status, err := p.fetchStatus(userID)
+	if err != nil {
+		p.API.LogError("Failed to fetch status after playback change", "userID", userID, "error", err)
+		return
+	}
+
+	if err := p.kvstore.StoreCacheStatus(userID, status); err != nil {
+		p.API.LogError("Failed to cache status", "userID", userID, "error", err)
+	}
+
+	if !status.IsPlaying || status.TrackName == "" {
+		return
+	}
+
+	attachment := trackAttachment(status)
+	attachment.Pretext = "Now playing"
+	post := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: channelID,
+	}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{attachment})
+	p.client.Post.SendEphemeralPost(userID, post)
+}
+
+// controlPlayback performs a playback action, returning a message describing what was done and whether playback was
+// changed. An invalid value is described in the message without changing playback.
+func controlPlayback(ctx context.Context, client *spotify.Client, action, value string) (string, bool, error) {
+	switch action {
+	case "play":
+		return "Resumed playback.", true, client.Play(ctx)
+
+	case "pause":
+		return "Paused playback.", true, client.Pause(ctx)
+
+	case "next":
+		return "Skipped to the next track.", true, client.Next(ctx)
+
+	case "previous":
+		return "Went back to the previous track.", true, client.Previous(ctx)
+
+	case "volume":
+		volume, err := strconv.Atoi(value)
+		if err != nil || volume < 0 || volume > 100 {
+			return "Volume must be a number between 0 and 100.", false, nil
+		}
+		return fmt.Sprintf("Set volume to %d%%.", volume), true, client.Volume(ctx, volume)
+
+	case "shuffle":
+		var shuffle bool
+		switch value {
+		case "on":
+			shuffle = true
+		case "off":
+			shuffle = false
+		case "":
+			// Toggle the current shuffle state
+			state, err := client.PlayerState(ctx)
+			if err != nil {
+				return "", false, err
+			}
+			shuffle = !state.ShuffleState
+		default:
+			return "Shuffle must be `on` or `off`.", false, nil
+		}
+		if shuffle {
+			return "Turned shuffle on.", true, client.Shuffle(ctx, true)
+		}
+		return "Turned shuffle off.", true, client.Shuffle(ctx, false)
+
+	case "repeat":
+		state := value
+		if state == "" {
+			// Cycle through the repeat states in the same order as the Spotify apps
+			playerState, err := client.PlayerState(ctx)
+			if err != nil {
+				return "", false, err
+			}
+			switch playerState.RepeatState {
+			case "off":
+				state = "context"
+			case "context":
+				state = "track"
+			default:
+				state = "off"
+			}
+		}
+		switch state {
+		case "off":
+			return "Turned repeat off.", true, client.Repeat(ctx, state)
+		case "context":
+			return "Repeating the current context.", true, client.Repeat(ctx, state)
+		case "track":
+			return "Repeating the current track.", true, client.Repeat(ctx, state)
+		}
+		return "Repeat must be `off`, `track` or `context`.", false, nil
+
+	default:
+		return fmt.Sprintf("Unknown playback action %s.", action), false, nil
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -10,10 +11,15 @@ import (
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
 	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
 	"github.com/pkg/errors"
+	"github.com/zmb3/spotify/v2"
 	spotifyauth "github.com/zmb3/spotify/v2/auth"
 )
 
+// pluginID is the plugin's manifest ID, used to build plugin URLs
+const pluginID = "com.clearstargroup.cs-mattermost-spotify-plugin"
+
 // Plugin implements the interface expected by the Mattermost server to communicate between the server and plugin processes.
 type Plugin struct {
 	plugin.MattermostPlugin
@@ -27,9 +33,24 @@ type Plugin struct {
 	// command is the client used to register and execute slash commands.
 	command command.Command
 
-	// auth is the Spotify authenticator (initialized in setConfiguration after configuration is loaded)
+	// botUserID is the user ID of the plugin bot used to send direct messages
+	botUserID string
+
+	// auth is the Spotify authenticator (initialized in OnConfigurationChange once credentials are configured).
+	// It is guarded by configurationLock, so use getAuth.
 	auth *spotifyauth.Authenticator
 
+	// appClient is the Spotify client authorized as the application rather than a user (initialized alongside auth).
+	// It is guarded by configurationLock, so use getAppClient.
+	appClient *spotify.Client
+
+	// spotifyCredentials are the settings auth and appClient were built from, to rebuild them when they change.
+	// It is guarded by configurationLock.
+	spotifyCredentials spotifyCredentials
+
+	// channelPlaylistJob is the scheduled job that posts weekly channel playlist summaries
+	channelPlaylistJob *cluster.Job
+
 	// configurationLock synchronizes access to the configuration.
 	configurationLock sync.RWMutex
 
@@ -57,11 +78,28 @@ func (p *Plugin) OnActivate() error {
 	}
 	p.command = command
 
+	// Create the plugin bot used for direct messages
+	if err := p.ensureBot(); err != nil {
+		return errors.Wrap(err, "failed to ensure bot")
+	}
+
+	// Schedule the job that posts weekly channel playlist summaries
+	job, err := cluster.Schedule(p.API, "channel-playlist-summaries", cluster.MakeWaitForRoundedInterval(time.Hour), p.postChannelPlaylistSummaries)
+	if err != nil {
+		return errors.Wrap(err, "failed to schedule channel playlist summaries")
+	}
+	p.channelPlaylistJob = job
+
 	return nil
 }
 
 // MatterMost plugin hook - invoked when the plugin is deactivated.
 func (p *Plugin) OnDeactivate() error {
+	if p.channelPlaylistJob != nil {
+		if err := p.channelPlaylistJob.Close(); err != nil {
+			return errors.Wrap(err, "failed to close channel playlist summary job")
+		}
+	}
 	return nil
 }
 
@@ -79,12 +117,32 @@ func (p *Plugin) RegisterCommand(command *model.Command) error {
 	return p.client.SlashCommand.Register(command)
 }
 
+// pluginURL returns the absolute URL under which this plugin's HTTP routes are served
+func (p *Plugin) pluginURL() string {
+	siteURL := ""
+	if config := p.API.GetConfig(); config != nil && config.ServiceSettings.SiteURL != nil {
+		siteURL = strings.TrimSuffix(*config.ServiceSettings.SiteURL, "/")
+	}
+	return siteURL + "/plugins/" + pluginID
+}
+
+// lock takes a cluster-wide lock on a key, returning the function to release it
+func (p *Plugin) lock(key string) (func(), error) {
+	mutex, err := cluster.NewMutex(p.API, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create mutex")
+	}
+	mutex.Lock()
+	return mutex.Unlock, nil
+}
+
 // Command Plugin API - generates the Spotify OAuth authorization URL
 func (p *Plugin) GetSpotifyAuthURL() (string, error) {
-	if p.auth == nil {
+	auth := p.getAuth()
+	if auth == nil {
 		return "", errors.New("Spotify not configured")
 	}
-	url := p.auth.AuthURL("123")
+	url := auth.AuthURL("123")
 	return url, nil
 }
 
@@ -95,6 +153,16 @@ func (p *Plugin) StoreUserEmail(userID, email string) error {
 
 // Command Plugin API - removes the user's Spotify integration
 func (p *Plugin) ClearUserData(userID string) error {
+	// Remove the plugin's custom status while its record is still there to recognise it
+	if err := p.clearCustomStatus(userID); err != nil {
+		p.API.LogError("Failed to clear custom status", "userID", userID, "error", err)
+	}
+
+	// Tracks can no longer be added to the user's playlists, so unlink them from their channels
+	if err := p.unlinkOwnedChannelPlaylists(userID); err != nil {
+		p.API.LogError("Failed to unlink channel playlists", "userID", userID, "error", err)
+	}
+
 	// Delete all user data
 	return p.kvstore.ClearUserData(userID)
 }
@@ -105,6 +173,11 @@ func (p *Plugin) ClearStatusCache(userID string) error {
 	return p.kvstore.StoreCacheStatus(userID, nil)
 }
 
+// Command Plugin API - checks whether a user has a permission
+func (p *Plugin) HasPermissionTo(userID string, permission *model.Permission) bool {
+	return p.API.HasPermissionTo(userID, permission)
+}
+
 // KVStore Plugin API - stores a value with optional expiration
 func (p *Plugin) KVSet(key string, value []byte, expirationSeconds ...int64) error {
 	if len(expirationSeconds) > 0 {
@@ -147,6 +220,23 @@ func (p *Plugin) KVDelete(key string) error {
 	return nil
 }
 
+// KVStore Plugin API - lists all keys with the given prefix
+func (p *Plugin) KVListKeys(prefix string) ([]string, error) {
+	const perPage = 100
+
+	var keys []string
+	for page := 0; ; page++ {
+		pageKeys, err := p.client.KV.ListKeys(page, perPage, pluginapi.WithPrefix(prefix))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list keys")
+		}
+		keys = append(keys, pageKeys...)
+		if len(pageKeys) < perPage {
+			return keys, nil
+		}
+	}
+}
+
 // KVStore and Command Plugin API - logging methods
 func (p *Plugin) LogInfo(message string, args ...any) {
 	p.API.LogInfo(message, args...)
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/clearstargroup/cs-mattermost-spotify-plugin/server/store/kvstore"
+	"github.com/pkg/errors"
+	"github.com/zmb3/spotify/v2"
+	spotifyauth "github.com/zmb3/spotify/v2/auth"
+)
+
+// recentLength is how many tracks are shown by /spotify recent
+const recentLength = 10
+
+// Command Plugin API - lists the tracks a user recently played, from Spotify for the requesting user or from the
+// recorded history for a teammate
+func (p *Plugin) Recent(userID, targetUsername string) (string, error) {
+	if targetUsername == "" {
+		return p.runAsUser(userID, []string{spotifyauth.ScopeUserReadRecentlyPlayed}, func(ctx context.Context, client *spotify.Client) (string, error) {
+			items, err := client.PlayerRecentlyPlayedOpt(ctx, &spotify.RecentlyPlayedOptions{Limit: recentLength})
+			if err != nil {
+				return "", err
+			}
+			if len(items) == 0 {
+				return "You haven't played anything on Spotify recently.", nil
+			}
+
+			history := make([]*kvstore.HistoryEntry, 0, len(items))
+			for _, item := range items {
+				history = append(history, &kvstore.HistoryEntry{
+					TrackID:      string(item.Track.ID),
+					TrackName:    item.Track.Name,
+					TrackArtists: joinArtistNames(item.Track.Artists),
+					ArtistNames:  artistNames(item.Track.Artists),
+					TrackURL:     item.Track.ExternalURLs["spotify"],
+					PlayedAt:     item.PlayedAt.UnixMilli(),
+				})
+			}
+			return "#### Your recently played tracks\n" + formatHistory(history, time.Now()), nil
+		}), nil
+	}
+
+	target, err := p.client.User.GetByUsername(strings.TrimPrefix(targetUsername, "@"))
+	if err != nil {
+		return "", errors.Errorf("user %s not found", targetUsername)
+	}
+
+	canView, err := p.canViewListening(userID, target.Id)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to check visibility")
+	}
+	if !canView {
+		return fmt.Sprintf("@%s doesn't share what they're listening to.", target.Username), nil
+	}
+
+	history, err := p.getHistory(target.Id, 0, recentLength)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get history")
+	}
+	if len(history) == 0 {
+		return fmt.Sprintf("No listening history recorded for @%s.", target.Username), nil
+	}
+
+	return fmt.Sprintf("#### @%s's recently played tracks\n", target.Username) + formatHistory(history, time.Now()), nil
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"strings"
+
+	"github.com/clearstargroup/cs-mattermost-spotify-plugin/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// maxStoredRecommendations limits how many recommendations are kept for each user
+const maxStoredRecommendations = 50
+
+// recommendationReplyState is carried through the reply dialog to identify the recommendation being replied to. The
+// recommendation is looked up in the replier's own inbox, as the state comes back from the client.
+type recommendationReplyState struct {
+	RecommendationID string
+}
+
+// Command Plugin API - reports whether a value is a Spotify track link, URI or ID, so it can be told apart from the
+// first word of a recommendation's note
+func (p *Plugin) IsSpotifyTrack(value string) bool {
+	_, ok := parseSpotifyID(value, "track")
+	return ok
+}
+
+// Command Plugin API - recommends a track, given as a Spotify link or "current", to another user
+func (p *Plugin) Recommend(userID, targetUsername, track, note string) (string, error) {
+	target, err := p.client.User.GetByUsername(strings.TrimPrefix(targetUsername, "@"))
+	if err != nil {
+		return "", errors.Errorf("user %s not found", targetUsername)
+	}
+	if target.IsBot {
+		return "", errors.New("bots can't receive recommendations")
+	}
+
+	settings, err := p.kvstore.GetUserSettings(target.Id)
+	if err != nil {
+		return "", err
+	}
+	if settings.RefuseRecommendations {
+		return "", errors.Errorf("@%s isn't accepting recommendations", target.Username)
+	}
+
+	preview, err := p.getRecommendedTrack(userID, track)
+	if err != nil {
+		return "", err
+	}
+
+	sender, err := p.client.User.Get(userID)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get sender")
+	}
+
+	recommendation := &kvstore.Recommendation{
+		ID:         model.NewId(),
+		FromUserID: userID,
+		Track:      *preview,
+		Note:       note,
+		CreatedAt:  model.GetMillis(),
+	}
+	if err := p.addRecommendation(target.Id, recommendation); err != nil {
+		return "", err
+	}
+
+	post := &model.Post{Message: fmt.Sprintf("@%s recommended a track for you.", sender.Username)}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{recommendationAttachment(recommendation, sender.Username)})
+	if p.shouldMessageRecommendation(target, settings) {
+		p.sendBotDM(target.Id, post)
+	}
+
+	p.API.LogInfo("Successfully sent recommendation", "userID", userID, "targetUserID", target.Id, "trackID", preview.ID)
+
+	return fmt.Sprintf("Recommended **%s** to @%s.", preview.Name, target.Username), nil
+}
+
+// Command Plugin API - lists a user's unread recommendations, marking them as read
+func (p *Plugin) ListRecommendations(userID string) (string, []*model.SlackAttachment, error) {
+	unlock, err := p.lock("recommendations-lock-" + userID)
+	if err != nil {
+		return "", nil, err
+	}
+	defer unlock()
+
+	recommendations, err := p.kvstore.GetRecommendations(userID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var attachments []*model.SlackAttachment
+	for _, recommendation := range recommendations {
+		if recommendation.Read {
+			continue
+		}
+
+		senderUsername := "someone"
+		if sender, err := p.client.User.Get(recommendation.FromUserID); err == nil {
+			senderUsername = sender.Username
+		}
+
+		attachments = append(attachments, recommendationAttachment(recommendation, senderUsername))
+		recommendation.Read = true
+	}
+
+	if len(attachments) == 0 {
+		return "You have no unread recommendations.", nil, nil
+	}
+
+	if err := p.kvstore.StoreRecommendations(userID, recommendations); err != nil {
+		return "", nil, err
+	}
+
+	return fmt.Sprintf("You have %d unread recommendations:", len(attachments)), attachments, nil
+}
+
+// Command Plugin API - sets whether a user accepts recommendations from other users
+func (p *Plugin) SetAcceptRecommendations(userID string, accept bool) error {
+	settings, err := p.kvstore.GetUserSettings(userID)
+	if err != nil {
+		return err
+	}
+
+	settings.RefuseRecommendations = !accept
+	return p.kvstore.StoreUserSettings(userID, settings)
+}
+
+// getRecommendedTrack resolves the track being recommended, either the sender's current track or a Spotify track link
+func (p *Plugin) getRecommendedTrack(userID, track string) (*kvstore.LinkPreview, error) {
+	if track == "current" {
+		status, err := p.fetchStatus(userID)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to fetch status")
+		}
+		if !status.IsPlaying || status.TrackID == "" {
+			return nil, errors.New("you're not currently playing a track on Spotify")
+		}
+
+		return &kvstore.LinkPreview{
+			Type:     "track",
+			ID:       status.TrackID,
+			Name:     status.TrackName,
+			Subtitle: status.TrackArtists,
+			URL:      status.TrackURL,
+			ImageURL: status.ImageURL,
+		}, nil
+	}
+
+	trackID, ok := parseSpotifyID(track, "track")
+	if !ok {
+		return nil, errors.Errorf("%s is not a Spotify track link", track)
+	}
+	if p.getAppClient() == nil {
+		return nil, errors.New("Spotify not configured")
+	}
+
+	return p.getLinkPreview(context.Background(), spotifyLink{Type: "track", ID: string(trackID)})
+}
+
+// addRecommendation adds a recommendation to the front of a user's inbox, dropping the oldest beyond the limit
+func (p *Plugin) addRecommendation(userID string, recommendation *kvstore.Recommendation) error {
+	unlock, err := p.lock("recommendations-lock-" + userID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	recommendations, err := p.kvstore.GetRecommendations(userID)
+	if err != nil {
+		return err
+	}
+
+	recommendations = append([]*kvstore.Recommendation{recommendation}, recommendations...)
+	if len(recommendations) > maxStoredRecommendations {
+		recommendations = recommendations[:maxStoredRecommendations]
+	}
+
+	return p.kvstore.StoreRecommendations(userID, recommendations)
+}
+
+// recommendationAttachment builds a message attachment for a recommended track, with buttons to queue, save or reply
+func recommendationAttachment(recommendation *kvstore.Recommendation, senderUsername string) *model.SlackAttachment {
+	attachment := linkPreviewAttachment(&recommendation.Track)
+
+	attachment.Pretext = fmt.Sprintf("From @%s", senderUsername)
+	if recommendation.Note != "" {
+		attachment.Pretext += ": " + recommendation.Note
+	}
+	attachment.Timestamp = recommendation.CreatedAt / 1000
+
+	actionContext := itemActionContext("track", recommendation.Track.ID, recommendation.Track.Name, recommendation.Track.URL)
+	replyContext := maps.Clone(actionContext)
+	replyContext["recommendation_id"] = recommendation.ID
+
+	attachment.Actions = []*model.PostAction{
+		newPostAction(itemActionQueue, "Add to queue", recommendation.Track.ID, actionContext),
+		newPostAction(itemActionSave, "Save to Liked Songs", recommendation.Track.ID, actionContext),
+		newPostAction(itemActionReply, "Reply", recommendation.ID, replyContext),
+	}
+
+	return attachment
+}
+
+// getRecommendation finds a recommendation in a user's inbox, returning nil if they didn't receive it
+func (p *Plugin) getRecommendation(userID, recommendationID string) (*kvstore.Recommendation, error) {
+	recommendations, err := p.kvstore.GetRecommendations(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, recommendation := range recommendations {
+		if recommendation.ID == recommendationID {
+			return recommendation, nil
+		}
+	}
+	return nil, nil
+}
+
+// openRecommendationReplyDialog opens a dialog for a user to reply to the sender of a recommendation they received
+func (p *Plugin) openRecommendationReplyDialog(userID, triggerID, recommendationID string) error {
+	recommendation, err := p.getRecommendation(userID, recommendationID)
+	if err != nil {
+		return err
+	}
+	if recommendation == nil {
+		return errors.New("recommendation not found, it may have been removed from your inbox")
+	}
+
+	sender, err := p.client.User.Get(recommendation.FromUserID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get sender")
+	}
+	trackName := recommendation.Track.Name
+
+	state, err := json.Marshal(recommendationReplyState{RecommendationID: recommendationID})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal dialog state")
+	}
+
+	return p.client.Frontend.OpenInteractiveDialog(model.OpenDialogRequest{
+		TriggerId: triggerID,
+		URL:       "/plugins/" + pluginID + "/api/v1/dialogs/recommendation-reply",
+		Dialog: model.Dialog{
+			CallbackId:       "recommendation-reply",
+			Title:            "Reply to @" + sender.Username,
+			IntroductionText: fmt.Sprintf("Let @%s know what you thought of **%s**.", sender.Username, trackName),
+			Elements: []model.DialogElement{
+				{
+					DisplayName: "Message",
+					Name:        "message",
+					Type:        "textarea",
+					MaxLength:   1000,
+				},
+			},
+			SubmitLabel: "Send",
+			State:       string(state),
+		},
+	})
+}
+
+// handleRecommendationReply handles submission of the recommendation reply dialog, forwarding the reply to the sender
+func (p *Plugin) handleRecommendationReply(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+
+	var request model.SubmitDialogRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		p.API.LogError("Failed to decode dialog submission", "error", err)
+		http.Error(w, "invalid dialog submission", http.StatusBadRequest)
+		return
+	}
+
+	var state recommendationReplyState
+	if err := json.Unmarshal([]byte(request.State), &state); err != nil || state.RecommendationID == "" {
+		p.API.LogError("Invalid dialog state", "state", request.State, "error", err)
+		http.Error(w, "invalid dialog state", http.StatusBadRequest)
+		return
+	}
+
+	message, _ := request.Submission["message"].(string)
+	if strings.TrimSpace(message) == "" {
+		p.writeDialogResponse(w, &model.SubmitDialogResponse{Errors: map[string]string{"message": "Enter a reply."}})
+		return
+	}
+
+	recommendation, err := p.getRecommendation(userID, state.RecommendationID)
+	if err != nil {
+		p.API.LogError("Failed to get recommendation", "userID", userID, "error", err)
+		http.Error(w, "failed to get recommendation", http.StatusInternalServerError)
+		return
+	}
+	if recommendation == nil {
+		http.Error(w, "recommendation not found", http.StatusNotFound)
+		return
+	}
+
+	replier, err := p.client.User.Get(userID)
+	if err != nil {
+		p.API.LogError("Failed to get user", "userID", userID, "error", err)
+		http.Error(w, "failed to get user", http.StatusInternalServerError)
+		return
+	}
+
+	p.sendBotDM(recommendation.FromUserID, &model.Post{
+		Message: fmt.Sprintf("@%s replied to your recommendation of **%s**:\n> %s", replier.Username, recommendation.Track.Name, strings.ReplaceAll(message, "\n", "\n> ")),
+	})
+
+	p.writeDialogResponse(w, &model.SubmitDialogResponse{})
+}
+
+// writeDialogResponse responds to an interactive dialog submission
+func (p *Plugin) writeDialogResponse(w http.ResponseWriter, response *model.SubmitDialogResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogError("Failed to encode dialog response", "error", err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/clearstargroup/cs-mattermost-spotify-plugin/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/zmb3/spotify/v2"
+)
+
+const (
+	// searchResultsPerType is how many results of each type are shown for a search by default
+	searchResultsPerType = 3
+	// maxSearchResultsPerType limits how many results of each type can be asked for
+	maxSearchResultsPerType = 10
+)
+
+// searchTypes maps the result types accepted by /spotify search to Spotify's search types
+var searchTypes = map[string]spotify.SearchType{
+	"track":    spotify.SearchTypeTrack,
+	"album":    spotify.SearchTypeAlbum,
+	"playlist": spotify.SearchTypePlaylist,
+	"artist":   spotify.SearchTypeArtist,
+}
+
+// Command Plugin API - searches Spotify for tracks, albums, playlists and artists, returning the top results with buttons to act on them.
+// Only the given result types are searched for, or all of them if none are given; a limit of zero shows the default number of each.
+func (p *Plugin) Search(userID, query string, types []string, limit int) (string, []*model.SlackAttachment) {
+	ctx := context.Background()
+
+	var searchType spotify.SearchType
+	for _, t := range types {
+		searchType |= searchTypes[t]
+	}
+	if searchType == 0 {
+		searchType = spotify.SearchTypeTrack | spotify.SearchTypeAlbum | spotify.SearchTypePlaylist | spotify.SearchTypeArtist
+	}
+	if limit <= 0 {
+		limit = searchResultsPerType
+	}
+
+	// Search as the user when connected so results suit their market, otherwise as the app
+	client, err := p.getSpotifyClient(ctx, userID)
+	if err != nil {
+		p.API.LogError("Failed to get Spotify client", "userID", userID, "error", err)
+	}
+	if client == nil {
+		client = p.getAppClient()
+	}
+	if client == nil {
+		return "Spotify not configured.", nil
+	}
+
+	result, err := client.Search(ctx, query, searchType, spotify.Limit(min(limit, maxSearchResultsPerType)))
+	if err != nil {
+		p.API.LogError("Failed to search Spotify", "query", query, "error", err)
+		return spotifyErrorMessage(err), nil
+	}
+
+	var attachments []*model.SlackAttachment
+	if result.Tracks != nil {
+		for _, track := range result.Tracks.Tracks {
+			attachments = append(attachments, searchResultAttachment(&kvstore.LinkPreview{
+				Type:       "track",
+				ID:         string(track.ID),
+				Name:       track.Name,
+				Subtitle:   joinArtistNames(track.Artists),
+				URL:        track.ExternalURLs["spotify"],
+				ImageURL:   firstImageURL(track.Album.Images),
+				DurationMs: int(track.Duration),
+			}))
+		}
+	}
+	if result.Albums != nil {
+		for _, album := range result.Albums.Albums {
+			attachments = append(attachments, searchResultAttachment(&kvstore.LinkPreview{
+				Type:     "album",
+				ID:       string(album.ID),
+				Name:     album.Name,
+				Subtitle: joinArtistNames(album.Artists),
+				URL:      album.ExternalURLs["spotify"],
+				ImageURL: firstImageURL(album.Images),
+			}))
+		}
+	}
+	if result.Playlists != nil {
+		for _, playlist := range result.Playlists.Playlists {
+			// Spotify can return empty entries for playlists that are unavailable
+			if playlist.ID == "" {
+				continue
+			}
+			attachments = append(attachments, searchResultAttachment(&kvstore.LinkPreview{
+				Type:       "playlist",
+				ID:         string(playlist.ID),
+				Name:       playlist.Name,
+				Subtitle:   playlist.Owner.DisplayName,
+				URL:        playlist.ExternalURLs["spotify"],
+				ImageURL:   firstImageURL(playlist.Images),
+				TrackCount: int(playlist.Tracks.Total),
+			}))
+		}
+	}
+	if result.Artists != nil {
+		for _, artist := range result.Artists.Artists {
+			attachments = append(attachments, searchResultAttachment(&kvstore.LinkPreview{
+				Type:     "artist",
+				ID:       string(artist.ID),
+				Name:     artist.Name,
+				URL:      artist.ExternalURLs["spotify"],
+				ImageURL: firstImageURL(artist.Images),
+			}))
+		}
+	}
+
+	if len(attachments) == 0 {
+		return fmt.Sprintf("No Spotify results for **%s**.", query), nil
+	}
+
+	return fmt.Sprintf("Spotify results for **%s**:", query), attachments
+}
+
+// searchResultAttachment builds a message attachment for a search result, with buttons to play, queue or post it
+func searchResultAttachment(preview *kvstore.LinkPreview) *model.SlackAttachment {
+	attachment := linkPreviewAttachment(preview)
+	actionContext := itemActionContext(preview.Type, preview.ID, preview.Name, preview.URL)
+
+	attachment.Actions = []*model.PostAction{newPostAction(itemActionPlay, "Play", preview.ID, actionContext)}
+	if preview.Type == "track" {
+		// Only tracks can be added to the queue, albums, playlists and artists are played as a whole
+		attachment.Actions = append(attachment.Actions, newPostAction(itemActionQueue, "Add to queue", preview.ID, actionContext))
+	}
+	attachment.Actions = append(attachment.Actions, newPostAction(itemActionPost, "Post to channel", preview.ID, actionContext))
+
+	return attachment
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/clearstargroup/cs-mattermost-spotify-plugin/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	"github.com/zmb3/spotify/v2"
+)
+
+// Who can see what a user is listening to
+const (
+	visibilityEveryone = "everyone"
+	visibilityNobody   = "nobody"
+)
+
+const (
+	// itemTypeEpisode is the type of a podcast episode when it is playing
+	itemTypeEpisode = "episode"
+	// customStatusEmoji is shown in the custom statuses set by the plugin
+	customStatusEmoji = "headphones"
+	// quietHoursOff is the dialog value for turning quiet hours off
+	quietHoursOff = "off"
+)
+
+// canViewListening reports whether a user may see what another user is listening to, following the other user's
+// visibility settings. Users can always see their own listening.
+func (p *Plugin) canViewListening(viewerID, userID string) (bool, error) {
+	if viewerID == userID {
+		return true, nil
+	}
+
+	settings, err := p.kvstore.GetUserSettings(userID)
+	if err != nil {
+		return false, err
+	}
+
+	return settings.Visibility != visibilityNobody, nil
+}
+
+// Command Plugin API - sets who can see what a user is listening to
+func (p *Plugin) SetVisibility(userID, visibility string) error {
+	if visibility != visibilityEveryone && visibility != visibilityNobody {
+		return errors.Errorf("unknown visibility %s", visibility)
+	}
+
+	settings, err := p.kvstore.GetUserSettings(userID)
+	if err != nil {
+		return err
+	}
+
+	settings.Visibility = visibility
+	return p.kvstore.StoreUserSettings(userID, settings)
+}
+
+// isQuietHours reports whether a time falls within a user's quiet hours, which may span midnight
+func isQuietHours(settings *kvstore.UserSettings, t time.Time) bool {
+	start, end := settings.QuietHoursStart, settings.QuietHoursEnd
+	if start == end {
+		return false
+	}
+
+	hour := t.Hour()
+	if start < end {
+		return hour >= start && hour < end
+	}
+	return hour >= start || hour < end
+}
+
+// shouldMessageRecommendation reports whether the bot should message a user about a recommendation they received
+func (p *Plugin) shouldMessageRecommendation(user *model.User, settings *kvstore.UserSettings) bool {
+	if settings.MuteRecommendationMessages {
+		return false
+	}
+	return !isQuietHours(settings, time.Now().In(user.GetTimezoneLocation()))
+}
+
+// syncCustomStatus sets a user's custom status to the track they're playing, if they have chosen to, until the
+// track ends. A custom status the user set themselves is left alone.
+func (p *Plugin) syncCustomStatus(userID string, state *spotify.PlayerState) error {
+	track := state.Item
+	if track == nil {
+		return nil
+	}
+
+	settings, err := p.kvstore.GetUserSettings(userID)
+	if err != nil {
+		return err
+	}
+	if !settings.SyncCustomStatus || settings.Visibility == visibilityNobody {
+		return nil
+	}
+
+	user, err := p.client.User.Get(userID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get user")
+	}
+	current := user.GetCustomStatus()
+	ours, err := p.isPluginCustomStatus(userID, current)
+	if err != nil {
+		return err
+	}
+	if !ours && current != nil && current.AreDurationAndExpirationTimeValid() {
+		return nil
+	}
+
+	text := fmt.Sprintf("%s - %s", track.Name, joinArtistNames(track.Artists))
+	if ours && current.Text == text {
+		return nil
+	}
+
+	customStatus := &model.CustomStatus{
+		Emoji:     customStatusEmoji,
+		Text:      text,
+		Duration:  "date_and_time",
+		ExpiresAt: time.Now().Add(time.Duration(track.Duration-state.Progress) * time.Millisecond),
+	}
+	customStatus.PreSave()
+	if appErr := p.API.UpdateUserCustomStatus(userID, customStatus); appErr != nil {
+		return errors.Wrap(appErr, "failed to update custom status")
+	}
+
+	return p.kvstore.StoreCustomStatus(userID, &kvstore.CustomStatus{
+		Text:      customStatus.Text,
+		ExpiresAt: customStatus.ExpiresAt.UnixMilli(),
+	})
+}
+
+// isPluginCustomStatus reports whether a user's custom status is the one the plugin last set for them, rather than
+// one they set themselves, even with the same emoji
+func (p *Plugin) isPluginCustomStatus(userID string, current *model.CustomStatus) (bool, error) {
+	if current == nil || current.Emoji != customStatusEmoji {
+		return false, nil
+	}
+
+	set, err := p.kvstore.GetCustomStatus(userID)
+	if err != nil {
+		return false, err
+	}
+
+	// The stored expiry is compared to the second, as Mattermost may not keep the exact time
+	return set != nil && set.Text == current.Text && set.ExpiresAt/1000 == current.ExpiresAt.Unix(), nil
+}
+
+// Command Plugin API - opens a dialog for a user to change their settings
+func (p *Plugin) OpenSettingsDialog(userID, triggerID string) error {
+	settings, err := p.kvstore.GetUserSettings(userID)
+	if err != nil {
+		return err
+	}
+
+	visibility := settings.Visibility
+	if visibility == "" {
+		visibility = visibilityEveryone
+	}
+	quietHoursStart, quietHoursEnd := quietHoursOff, quietHoursOff
+	if settings.QuietHoursStart != settings.QuietHoursEnd {
+		quietHoursStart, quietHoursEnd = strconv.Itoa(settings.QuietHoursStart), strconv.Itoa(settings.QuietHoursEnd)
+	}
+
+	hours := []*model.PostActionOptions{{Text: "Off", Value: quietHoursOff}}
+	for hour := range 24 {
+		hours = append(hours, &model.PostActionOptions{Text: fmt.Sprintf("%02d:00", hour), Value: strconv.Itoa(hour)})
+	}
+
+	return p.client.Frontend.OpenInteractiveDialog(model.OpenDialogRequest{
+		TriggerId: triggerID,
+		URL:       "/plugins/" + pluginID + "/api/v1/dialogs/settings",
+		Dialog: model.Dialog{
+			CallbackId: "settings",
+			Title:      "Spotify settings",
+			Elements: []model.DialogElement{
+				{
+					DisplayName: "Who can see what you're listening to",
+					Name:        "visibility",
+					Type:        "select",
+					Default:     visibility,
+					Options: []*model.PostActionOptions{
+						{Text: "Everyone", Value: visibilityEveryone},
+						{Text: "Nobody", Value: visibilityNobody},
+					},
+				},
+				{
+					DisplayName: "Custom status",
+					Name:        "sync_custom_status",
+					Type:        "bool",
+					Placeholder: "Set my custom status to the track I'm playing",
+					Default:     strconv.FormatBool(settings.SyncCustomStatus),
+					HelpText:    "Your own custom status is never replaced.",
+					Optional:    true,
+				},
+				{
+					DisplayName: "Podcasts",
+					Name:        "share_podcasts",
+					Type:        "bool",
+					Placeholder: "Show others when I'm listening to a podcast",
+					Default:     strconv.FormatBool(!settings.HidePodcasts),
+					Optional:    true,
+				},
+				{
+					DisplayName: "Recommendations",
+					Name:        "accept_recommendations",
+					Type:        "bool",
+					Placeholder: "Let teammates recommend tracks to me",
+					Default:     strconv.FormatBool(!settings.RefuseRecommendations),
+					Optional:    true,
+				},
+				{
+					DisplayName: "Notifications",
+					Name:        "message_recommendations",
+					Type:        "bool",
+					Placeholder: "Message me when I receive a recommendation",
+					Default:     strconv.FormatBool(!settings.MuteRecommendationMessages),
+					HelpText:    "Recommendations are always kept in `/spotify inbox`.",
+					Optional:    true,
+				},
+				{
+					DisplayName: "Quiet hours start",
+					Name:        "quiet_hours_start",
+					Type:        "select",
+					Default:     quietHoursStart,
+					Options:     hours,
+					HelpText:    "No recommendation messages are sent during quiet hours, in your timezone.",
+				},
+				{
+					DisplayName: "Quiet hours end",
+					Name:        "quiet_hours_end",
+					Type:        "select",
+					Default:     quietHoursEnd,
+					Options:     hours,
+				},
+			},
+			SubmitLabel: "Save",
+		},
+	})
+}
+
+// handleSettingsDialog handles submission of the settings dialog, validating and storing the user's settings
+func (p *Plugin) handleSettingsDialog(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+
+	var request model.SubmitDialogRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		p.API.LogError("Failed to decode dialog submission", "error", err)
+		http.Error(w, "invalid dialog submission", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := p.kvstore.GetUserSettings(userID)
+	if err != nil {
+		p.API.LogError("Failed to get user settings", "userID", userID, "error", err)
+		http.Error(w, "failed to get user settings", http.StatusInternalServerError)
+		return
+	}
+
+	validationErrors := map[string]string{}
+
+	visibility, _ := request.Submission["visibility"].(string)
+	if visibility != visibilityEveryone && visibility != visibilityNobody {
+		validationErrors["visibility"] = "Choose who can see what you're listening to."
+	}
+
+	quietHoursStart, startOK := parseQuietHour(request.Submission["quiet_hours_start"])
+	if !startOK {
+		validationErrors["quiet_hours_start"] = "Choose an hour of the day."
+	}
+	quietHoursEnd, endOK := parseQuietHour(request.Submission["quiet_hours_end"])
+	if !endOK {
+		validationErrors["quiet_hours_end"] = "Choose an hour of the day."
+	}
+	if startOK && endOK {
+		switch {
+		case (quietHoursStart < 0) != (quietHoursEnd < 0):
+			validationErrors["quiet_hours_end"] = "Set both the start and end of quiet hours, or turn both off."
+		case quietHoursStart >= 0 && quietHoursStart == quietHoursEnd:
+			validationErrors["quiet_hours_end"] = "Quiet hours must end at a different time than they start."
+		}
+	}
+
+	if len(validationErrors) > 0 {
+		p.writeDialogResponse(w, &model.SubmitDialogResponse{Errors: validationErrors})
+		return
+	}
+
+	settings.Visibility = visibility
+	settings.SyncCustomStatus = dialogBool(request.Submission["sync_custom_status"])
+	settings.HidePodcasts = !dialogBool(request.Submission["share_podcasts"])
+	settings.RefuseRecommendations = !dialogBool(request.Submission["accept_recommendations"])
+	settings.MuteRecommendationMessages = !dialogBool(request.Submission["message_recommendations"])
+	settings.QuietHoursStart, settings.QuietHoursEnd = max(quietHoursStart, 0), max(quietHoursEnd, 0)
+
+	if err := p.kvstore.StoreUserSettings(userID, settings); err != nil {
+		p.API.LogError("Failed to store user settings", "userID", userID, "error", err)
+		http.Error(w, "failed to store user settings", http.StatusInternalServerError)
+		return
+	}
+
+	// Clear the plugin's custom status straight away when syncing is turned off or listening is hidden
+	if !settings.SyncCustomStatus || settings.Visibility == visibilityNobody {
+		if err := p.clearCustomStatus(userID); err != nil {
+			p.API.LogError("Failed to clear custom status", "userID", userID, "error", err)
+		}
+	}
+
+	p.writeDialogResponse(w, &model.SubmitDialogResponse{})
+}
+
+// clearCustomStatus removes a custom status set by the plugin, leaving one the user set themselves
+func (p *Plugin) clearCustomStatus(userID string) error {
+	user, err := p.client.User.Get(userID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get user")
+	}
+
+	ours, err := p.isPluginCustomStatus(userID, user.GetCustomStatus())
+	if err != nil {
+		return err
+	}
+	if ours {
+		if appErr := p.API.RemoveUserCustomStatus(userID); appErr != nil {
+			return errors.Wrap(appErr, "failed to remove custom status")
+		}
+	}
+	return p.kvstore.DeleteCustomStatus(userID)
+}
+
+// parseQuietHour parses an hour submitted for quiet hours, returning -1 when quiet hours are off, and whether the
+// hour is valid
+func parseQuietHour(value any) (int, bool) {
+	text, _ := value.(string)
+	if text == "" || text == quietHoursOff {
+		return -1, true
+	}
+
+	hour, err := strconv.Atoi(text)
+	if err != nil || hour < 0 || hour > 23 {
+		return 0, false
+	}
+	return hour, true
+}
+
+// dialogBool reads a checkbox from a dialog submission, which may be submitted as a boolean or a string
+func dialogBool(value any) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	default:
+		return false
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/clearstargroup/cs-mattermost-spotify-plugin/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// Command Plugin API - posts the user's currently playing track into a channel
+func (p *Plugin) ShareCurrentTrack(userID, channelID, rootID, message string) error {
+	// Always fetch a fresh status so the shared track is what's playing right now
+	status, err := p.fetchStatus(userID)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch status")
+	}
+
+	if !status.IsConnected {
+		return errors.New("Spotify is not connected, run /spotify enable your@spotifyemail.com first")
+	}
+	if !status.IsPlaying || status.TrackName == "" {
+		return errors.New("you're not currently playing a track on Spotify")
+	}
+
+	// Update the cache while we have a fresh status
+	if err := p.kvstore.StoreCacheStatus(userID, status); err != nil {
+		p.API.LogError("Failed to cache status", "userID", userID, "error", err)
+	}
+
+	post := &model.Post{
+		UserId:    userID,
+		ChannelId: channelID,
+		RootId:    rootID,
+		Message:   message,
+	}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{trackAttachment(status)})
+
+	if err := p.client.Post.CreatePost(post); err != nil {
+		return errors.Wrap(err, "failed to create post")
+	}
+
+	p.API.LogInfo("Successfully shared current track", "userID", userID, "channelID", channelID, "trackID", status.TrackID)
+
+	return nil
+}
+
+// trackAttachment builds a message attachment describing the track in a status
+func trackAttachment(status *kvstore.Status) *model.SlackAttachment {
+	attachment := &model.SlackAttachment{
+		Fallback:   fmt.Sprintf("%s by %s on Spotify: %s", status.TrackName, status.TrackArtists, status.TrackURL),
+		Color:      spotifyColor,
+		AuthorName: "Spotify",
+		AuthorLink: "https://open.spotify.com",
+		Title:      status.TrackName,
+		TitleLink:  status.TrackURL,
+		Text:       status.TrackArtists,
+		ThumbURL:   status.ImageURL,
+		Actions:    trackActions(status.TrackID, status.TrackName, status.TrackURL),
+	}
+
+	if status.AlbumName != "" {
+		attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+			Title: "Album",
+			Value: status.AlbumName,
+			Short: true,
+		})
+	}
+
+	// Include the album, playlist, artist or show the track is being played from
+	if status.PlaybackName != "" && status.PlaybackType != "Album" {
+		value := status.PlaybackName
+		if status.PlaybackURL != "" {
+			value = fmt.Sprintf("[%s](%s)", status.PlaybackName, status.PlaybackURL)
+		}
+		attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+			Title: "Playing from " + status.PlaybackType,
+			Value: value,
+			Short: true,
+		})
+	}
+
+	return attachment
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/clearstargroup/cs-mattermost-spotify-plugin/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	"github.com/zmb3/spotify/v2"
+	spotifyauth "github.com/zmb3/spotify/v2/auth"
+	"golang.org/x/oauth2"
+)
+
+// baseScopes are the OAuth scopes requested from every user when they connect. Features needing
+// further scopes request them incrementally through reconnectURL.
+var baseScopes = []string{
+	spotifyauth.ScopeUserReadPrivate,
+	spotifyauth.ScopeUserReadEmail,
+	spotifyauth.ScopeUserReadPlaybackState,
+}
+
+// getSpotifyClient returns a Spotify client authorized as the given user, refreshing their token if it is expiring soon.
+// Returns a nil client if the user has not connected Spotify, or if their authorization is no longer valid.
+func (p *Plugin) getSpotifyClient(ctx context.Context, userID string) (*spotify.Client, error) {
+	auth := p.getAuth()
+	if auth == nil {
+		return nil, errors.New("Spotify not configured")
+	}
+
+	// Get token from KV store for the target user
+	tok, err := p.kvstore.GetToken(userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading token for user")
+	}
+
+	// If no token, the user is not connected
+	if tok == nil {
+		return nil, nil
+	}
+
+	// Refresh token if it's expiring soon (within 5m30s)
+	if m, _ := time.ParseDuration("5m30s"); time.Until(tok.Expiry) < m {
+		newToken, tokenErr := auth.RefreshToken(ctx, tok)
+		if isGrantRevoked(tokenErr) {
+			p.handleGrantRevoked(userID)
+			return nil, nil
+		}
+		if tokenErr != nil || newToken == nil {
+			return nil, errors.Wrap(tokenErr, "failed to refresh token")
+		}
+
+		// Store refreshed token
+		err = p.kvstore.StoreToken(userID, newToken)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to store refreshed token")
+		}
+		tok = newToken
+	}
+
+	return spotify.New(auth.Client(ctx, tok)), nil
+}
+
+// isGrantRevoked reports whether a token refresh failed because Spotify no longer accepts the refresh token
+func isGrantRevoked(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	return errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant"
+}
+
+// handleGrantRevoked discards a user's unusable token and lets them know they need to reconnect
+func (p *Plugin) handleGrantRevoked(userID string) {
+	p.API.LogInfo("Spotify authorization revoked or expired", "userID", userID)
+
+	if err := p.kvstore.DeleteToken(userID); err != nil {
+		p.API.LogError("Failed to delete revoked token", "userID", userID, "error", err)
+	}
+
+	if err := p.kvstore.StoreCacheStatus(userID, nil); err != nil {
+		p.API.LogError("Failed to clear cached status", "userID", userID, "error", err)
+	}
+
+	p.updateConnectionInfo(userID, func(info *kvstore.ConnectionInfo) {
+		info.LastError = "Spotify authorization was revoked or expired"
+		info.LastErrorAt = model.GetMillis()
+	})
+
+	p.notifyGrantRevoked(userID)
+}
+
+// getGrantedScopes returns the OAuth scopes a user has granted. Users who connected before scopes were
+// recorded are assumed to have granted the base scopes.
+func (p *Plugin) getGrantedScopes(userID string) ([]string, error) {
+	scopes, err := p.kvstore.GetScopes(userID)
+	if err != nil {
+		return nil, err
+	}
+	if scopes == nil {
+		return baseScopes, nil
+	}
+	return scopes, nil
+}
+
+// getMissingScopes returns which of the required OAuth scopes a user has not granted
+func (p *Plugin) getMissingScopes(userID string, required ...string) ([]string, error) {
+	granted, err := p.getGrantedScopes(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, scope := range required {
+		if !slices.Contains(granted, scope) {
+			missing = append(missing, scope)
+		}
+	}
+	return missing, nil
+}
+
+// reconnectURL returns the URL that sends a user to Spotify to reauthorize, additionally requesting the given scopes
+func (p *Plugin) reconnectURL(scopes ...string) string {
+	connectURL := p.pluginURL() + "/api/v1/connect"
+	if len(scopes) == 0 {
+		return connectURL
+	}
+	return connectURL + "?" + url.Values{"scope": scopes}.Encode()
+}
+
+// runAsUser runs an action with a user's Spotify client, after checking they are connected and have granted the
+// required scopes. Returns the message to show the user, which explains how to reconnect if scopes are missing.
+func (p *Plugin) runAsUser(userID string, requiredScopes []string, action func(ctx context.Context, client *spotify.Client) (string, error)) string {
+	ctx := context.Background()
+
+	client, err := p.getSpotifyClient(ctx, userID)
+	if err != nil {
+		p.API.LogError("Failed to get Spotify client", "userID", userID, "error", err)
+		return "Failed to connect to Spotify: " + err.Error()
+	}
+	if client == nil {
+		return "Spotify is not connected, run `/spotify enable your@spotifyemail.com` first."
+	}
+
+	missing, err := p.getMissingScopes(userID, requiredScopes...)
+	if err != nil {
+		p.API.LogError("Failed to get missing scopes", "userID", userID, "error", err)
+		return "Failed to check Spotify permissions: " + err.Error()
+	}
+	if len(missing) > 0 {
+		return fmt.Sprintf("This needs additional Spotify permissions. [Reconnect Spotify](%s) to grant them, then try again.", p.reconnectURL(missing...))
+	}
+
+	message, err := action(ctx, client)
+	if err != nil {
+		p.API.LogError("Failed to run Spotify action", "userID", userID, "error", err)
+		return spotifyErrorMessage(err)
+	}
+	return message
+}
+
+// spotifyErrorMessage converts an error from the Spotify API into a message for the user
+func spotifyErrorMessage(err error) string {
+	var spotifyErr spotify.Error
+	if errors.As(err, &spotifyErr) {
+		switch {
+		case spotifyErr.Status == http.StatusNotFound && strings.Contains(spotifyErr.Message, "device"):
+			return "No active Spotify device found. Start playing on one of your devices and try again."
+		case spotifyErr.Status == http.StatusForbidden:
+			return "Spotify refused the request: " + spotifyErr.Message + ". Some actions need Spotify Premium."
+		}
+		return "Spotify returned an error: " + spotifyErr.Message
+	}
+	return "Failed to reach Spotify: " + err.Error()
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clearstargroup/cs-mattermost-spotify-plugin/server/store/kvstore"
+	"github.com/gorilla/mux"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// statusFetchConcurrency limits how many statuses are fetched from Spotify at once for a batch
+	statusFetchConcurrency = 8
+	// maxListeningChannelMembers limits how many members of a channel are checked for what they're listening to
+	maxListeningChannelMembers = 1000
+	// maxListeningLiveFetches limits how many members without a cached status have it fetched from Spotify when
+	// checking who is listening in a channel, so the response isn't held up on large channels
+	maxListeningLiveFetches = 3 * statusFetchConcurrency
+)
+
+// channelListener is a channel member who is currently playing music
+type channelListener struct {
+	UserID   string
+	Username string
+	Status   *kvstore.Status
+}
+
+// getStatus returns a user's cached status, fetching and caching it from Spotify if necessary
+func (p *Plugin) getStatus(userID string) (*kvstore.Status, error) {
+	status, err := p.kvstore.GetCachedStatus(userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get cached status")
+	}
+	if status != nil {
+		return status, nil
+	}
+
+	status, err = p.fetchStatus(userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch status")
+	}
+
+	if err := p.kvstore.StoreCacheStatus(userID, status); err != nil {
+		return nil, errors.Wrap(err, "failed to cache status")
+	}
+
+	return status, nil
+}
+
+// getVisibleStatus returns a user's status as seen by another user, which is hidden if the user's visibility
+// settings don't allow the viewer to see it
+func (p *Plugin) getVisibleStatus(viewerID, userID string) (*kvstore.Status, error) {
+	canView, err := p.canViewListening(viewerID, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check visibility")
+	}
+	if !canView {
+		return &kvstore.Status{IsHidden: true}, nil
+	}
+
+	status, err := p.getStatus(userID)
+	if err != nil || viewerID == userID || status.ItemType != itemTypeEpisode {
+		return status, err
+	}
+
+	// Podcasts are shown as not playing to others when the user hides them
+	settings, err := p.kvstore.GetUserSettings(userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get user settings")
+	}
+	if settings.HidePodcasts {
+		return &kvstore.Status{IsConnected: true}, nil
+	}
+
+	return status, nil
+}
+
+// getVisibleStatuses returns the statuses of several users as seen by another user, fetching uncached statuses
+// concurrently. Users whose status couldn't be fetched are left out.
+func (p *Plugin) getVisibleStatuses(viewerID string, userIDs []string) map[string]*kvstore.Status {
+	statuses := make(map[string]*kvstore.Status, len(userIDs))
+	var statusesLock sync.Mutex
+
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, statusFetchConcurrency)
+	for _, userID := range userIDs {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(userID string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			status, err := p.getVisibleStatus(viewerID, userID)
+			if err != nil {
+				p.API.LogError("Failed to get status", "userID", userID, "error", err)
+				return
+			}
+
+			statusesLock.Lock()
+			statuses[userID] = status
+			statusesLock.Unlock()
+		}(userID)
+	}
+	wg.Wait()
+
+	return statuses
+}
+
+// getChannelListeners returns the members of a channel who are visibly playing music, ordered by username, and how
+// many connected members weren't checked as their status wasn't cached and too many others needed fetching
+func (p *Plugin) getChannelListeners(viewerID, channelID string) ([]*channelListener, int, error) {
+	var members []*model.User
+	for page := 0; len(members) < maxListeningChannelMembers; page++ {
+		users, err := p.client.User.ListInChannel(channelID, model.ChannelSortByUsername, page, 200)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "failed to list channel members")
+		}
+		for _, user := range users {
+			if !user.IsBot && user.DeleteAt == 0 {
+				members = append(members, user)
+			}
+		}
+		if len(users) < 200 {
+			break
+		}
+	}
+
+	connectedUserIDs, err := p.kvstore.ListConnectedUserIDs()
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to list connected users")
+	}
+
+	// Cached statuses are always used, but only a few are fetched live from Spotify
+	var userIDs []string
+	liveFetches, unchecked := 0, 0
+	for _, member := range members {
+		if !slices.Contains(connectedUserIDs, member.Id) {
+			continue
+		}
+		cached, err := p.kvstore.GetCachedStatus(member.Id)
+		if err != nil {
+			p.API.LogError("Failed to get cached status", "userID", member.Id, "error", err)
+			continue
+		}
+		if cached == nil {
+			if liveFetches == maxListeningLiveFetches {
+				unchecked++
+				continue
+			}
+			liveFetches++
+		}
+		userIDs = append(userIDs, member.Id)
+	}
+	statuses := p.getVisibleStatuses(viewerID, userIDs)
+
+	listeners := []*channelListener{}
+	for _, member := range members {
+		if status := statuses[member.Id]; status != nil && status.IsPlaying {
+			listeners = append(listeners, &channelListener{UserID: member.Id, Username: member.Username, Status: status})
+		}
+	}
+
+	return listeners, unchecked, nil
+}
+
+// Command Plugin API - lists the members of a channel who are currently playing music
+func (p *Plugin) WhoIsListening(userID, channelID string) (string, error) {
+	listeners, unchecked, err := p.getChannelListeners(userID, channelID)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	if len(listeners) == 0 {
+		sb.WriteString("Nobody in this channel is listening to Spotify right now.\n")
+	} else {
+		sb.WriteString("#### Listening in this channel\n")
+		for _, listener := range listeners {
+			fmt.Fprintf(&sb, "- @%s: %s\n", listener.Username, describeStatus(listener.Status))
+		}
+	}
+	if unchecked > 0 {
+		fmt.Fprintf(&sb, "\n%d more connected members weren't checked, as their status has to be fetched from Spotify. Run the command again shortly to check them.", unchecked)
+	}
+	return sb.String(), nil
+}
+
+// describeStatus summarises what a status says is playing as markdown
+func describeStatus(status *kvstore.Status) string {
+	var description string
+	switch {
+	case status.TrackName != "" && status.TrackURL != "":
+		description = fmt.Sprintf("**[%s](%s)** by %s", status.TrackName, status.TrackURL, status.TrackArtists)
+	case status.TrackName != "":
+		description = fmt.Sprintf("**%s** by %s", status.TrackName, status.TrackArtists)
+	default:
+		description = "Playing " + strings.ToLower(status.PlaybackType)
+	}
+
+	if status.PlaybackName != "" && status.PlaybackType != "Album" {
+		if status.PlaybackURL != "" {
+			description += fmt.Sprintf(" from [%s](%s)", status.PlaybackName, status.PlaybackURL)
+		} else {
+			description += " from " + status.PlaybackName
+		}
+	}
+
+	return description
+}
+
+// handleChannelListening returns the members of a channel who are currently playing music
+func (p *Plugin) handleChannelListening(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	channelID := mux.Vars(r)["channelId"]
+
+	// Only members of the channel can see who's listening in it
+	if _, err := p.client.Channel.GetMember(channelID, userID); err != nil {
+		p.API.LogError("User is not a channel member", "userID", userID, "channelID", channelID, "error", err)
+		http.Error(w, "not a member of the channel", http.StatusForbidden)
+		return
+	}
+
+	listeners, _, err := p.getChannelListeners(userID, channelID)
+	if err != nil {
+		p.API.LogError("Failed to get channel listeners", "channelID", channelID, "error", err)
+		http.Error(w, "failed to get channel listeners", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(listeners); err != nil {
+		p.API.LogError("Failed to encode response", "error", err)
+	}
+}
+
+// Command Plugin API - shows what a user is listening to, respecting their visibility settings. Without a
+// username it shows the requesting user's own status and connection.
+func (p *Plugin) UserStatus(userID, targetUsername string) (string, []*model.SlackAttachment, error) {
+	targetID, name := userID, "You"
+	if targetUsername != "" {
+		target, err := p.client.User.GetByUsername(strings.TrimPrefix(targetUsername, "@"))
+		if err != nil {
+			return "", nil, errors.Errorf("user %s not found", targetUsername)
+		}
+		targetID, name = target.Id, "@"+target.Username
+	}
+	self := targetID == userID
+	verb := "is"
+	if self {
+		verb = "are"
+	}
+
+	status, err := p.getVisibleStatus(userID, targetID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var text string
+	var attachments []*model.SlackAttachment
+	switch {
+	case status.IsHidden:
+		return fmt.Sprintf("%s doesn't share what they're listening to.", name), nil, nil
+	case !status.IsConnected && self:
+		return "Spotify is not connected, run `/spotify enable your@spotifyemail.com` first.", nil, nil
+	case !status.IsConnected:
+		return fmt.Sprintf("%s hasn't connected Spotify.", name), nil, nil
+	case status.IsPlaying && status.TrackName != "":
+		text = fmt.Sprintf("%s %s listening to:", name, verb)
+		attachments = append(attachments, trackAttachment(status))
+	case status.IsPlaying:
+		text = fmt.Sprintf("%s: %s", name, describeStatus(status))
+	case status.LastPlayedAt != 0 && status.TrackName != "":
+		text = fmt.Sprintf("%s last listened to %s, %s.", name, describeStatus(status), formatTimeAgo(time.UnixMilli(status.LastPlayedAt), time.Now()))
+	default:
+		text = fmt.Sprintf("%s %s not playing anything right now.", name, verb)
+	}
+
+	// Include the connection details when users look up their own status
+	if self {
+		if email, err := p.kvstore.GetEmailByUserID(userID); err == nil {
+			text = fmt.Sprintf("Connected to Spotify as %s.\n%s", email, text)
+		}
+	}
+
+	return text, attachments, nil
+}
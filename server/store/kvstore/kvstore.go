@@ -5,17 +5,135 @@ import (
 )
 
 type Status struct {
+	// IsHidden is set when the user's visibility settings hide their listening from the requester
+	IsHidden     bool
 	IsConnected  bool
 	IsPlaying    bool
 	PlaybackType string
 	PlaybackURL  string
 	PlaybackName string
+	// ItemType is the type of what is playing, "track" or "episode"
+	ItemType     string `json:",omitempty"`
+	TrackID      string
+	TrackName    string
+	TrackArtists string
+	TrackURL     string
+	AlbumName    string
+	ImageURL     string
+	// LastPlayedAt is set when the user isn't playing, with the track fields describing what they last listened to,
+	// in milliseconds since the epoch
+	LastPlayedAt int64 `json:",omitempty"`
+}
+
+// LinkPreview describes a Spotify track, album, playlist, artist or episode linked in a post
+type LinkPreview struct {
+	Type       string
+	ID         string
+	Name       string
+	Subtitle   string
+	URL        string
+	ImageURL   string
+	DurationMs int
+	TrackCount int
+}
+
+// ChannelPlaylist links a channel to a Spotify playlist that collects the tracks posted in it
+type ChannelPlaylist struct {
+	ChannelID    string
+	PlaylistID   string
+	PlaylistName string
+	PlaylistURL  string
+	// OwnerUserID is the Mattermost user whose Spotify account owns the playlist and adds tracks to it
+	OwnerUserID string
+	// TrackIDs are the tracks already in the playlist, used to avoid adding duplicates
+	TrackIDs []string
+	// AddedSinceSummary counts tracks added since the last weekly summary was posted
+	AddedSinceSummary int
+	LastSummaryAt     int64
+}
+
+// UserSettings are a user's preferences for the plugin. The zero value holds the defaults.
+type UserSettings struct {
+	RefuseRecommendations bool
+	// Visibility controls who can see what the user is listening to, "everyone" if empty
+	Visibility string
+	// SyncCustomStatus sets the user's Mattermost custom status to the track they're playing
+	SyncCustomStatus bool
+	// HidePodcasts hides what the user is listening to from others while they play a podcast
+	HidePodcasts bool
+	// MuteRecommendationMessages stops the bot messaging the user when they receive a recommendation
+	MuteRecommendationMessages bool
+	// QuietHoursStart and QuietHoursEnd are the hours of the day, in the user's timezone, between which the bot
+	// doesn't message the user about recommendations. Quiet hours are off when they are equal.
+	QuietHoursStart int
+	QuietHoursEnd   int
+}
+
+// CustomStatus records the custom status the plugin last set for a user, so it can be told apart from a custom status
+// the user set themselves
+type CustomStatus struct {
+	Text string
+	// ExpiresAt is when the custom status expires, in milliseconds since the epoch
+	ExpiresAt int64
+}
+
+// Recommendation is a track one user recommended to another
+type Recommendation struct {
+	ID         string
+	FromUserID string
+	Track      LinkPreview
+	Note       string
+	CreatedAt  int64
+	Read       bool
+}
+
+// ConnectionInfo records details of a user's Spotify connection, for diagnosing connection problems
+type ConnectionInfo struct {
+	SpotifyDisplayName string
+	ConnectedAt        int64
+	// LastFetchAt is when the user's status was last successfully fetched from Spotify
+	LastFetchAt int64
+	// LastError is the most recent error fetching the user's status, cleared once a fetch succeeds
+	LastError   string
+	LastErrorAt int64
+}
+
+// HistoryEntry is a single play of a track recorded in a user's listening history
+type HistoryEntry struct {
+	TrackID      string
+	TrackName    string
+	TrackArtists string
+	// ArtistNames lists the track's artists separately, as their names may contain commas. It is empty for entries
+	// recorded before it was added.
+	ArtistNames []string
+	AlbumName   string
+	TrackURL    string
+	// PlayedAt is when the play started, in milliseconds since the epoch
+	PlayedAt   int64
+	DurationMs int
+}
+
+// TopList is a user's ranked top artists or tracks for a time range
+type TopList struct {
+	// FromHistory is set when the list was calculated from the plugin's recorded history rather than by Spotify
+	FromHistory bool
+	Items       []TopItem
+}
+
+// TopItem is an artist or track in a TopList
+type TopItem struct {
+	Name     string
+	Subtitle string
+	URL      string
+	ImageURL string
+	Plays    int
 }
 
 type PluginAPI interface {
 	KVSet(key string, value []byte, expirationSeconds ...int64) error
 	KVGet(key string) ([]byte, error)
 	KVDelete(key string) error
+	KVListKeys(prefix string) ([]string, error)
 	GetStatusCacheDurationMinutes() int
 	LogInfo(message string, args ...any)
 }
@@ -30,15 +148,62 @@ type KVStore interface {
 	// OAuth token management
 	StoreToken(userID string, token *oauth2.Token) error
 	GetToken(userID string) (*oauth2.Token, error)
+	DeleteToken(userID string) error
+	ListConnectedUserIDs() ([]string, error)
+
+	// Connection details for diagnostics
+	StoreConnectionInfo(userID string, info *ConnectionInfo) error
+	GetConnectionInfo(userID string) (*ConnectionInfo, error)
+
+	// Granted OAuth scopes
+	StoreScopes(userID string, scopes []string) error
+	GetScopes(userID string) ([]string, error)
 
 	// Status caching
 	StoreCacheStatus(userID string, status *Status) error
 	GetCachedStatus(userID string) (*Status, error)
 
+	// Last playing status, kept after playback stops
+	StoreLastPlayed(userID string, status *Status) error
+	GetLastPlayed(userID string) (*Status, error)
+
 	// Context caching (artist, playlist, album, show names)
 	StoreContextName(contextType, contextID, name string) error
 	GetContextName(contextType, contextID string) (string, error)
 
+	// Link preview caching (tracks, albums, playlists, artists, episodes)
+	StoreLinkPreview(preview *LinkPreview) error
+	GetLinkPreview(linkType, linkID string) (*LinkPreview, error)
+
+	// Channel playlists
+	StoreChannelPlaylist(channelPlaylist *ChannelPlaylist) error
+	GetChannelPlaylist(channelID string) (*ChannelPlaylist, error)
+	DeleteChannelPlaylist(channelID string) error
+	ListChannelPlaylistChannelIDs() ([]string, error)
+
+	// User settings
+	StoreUserSettings(userID string, settings *UserSettings) error
+	GetUserSettings(userID string) (*UserSettings, error)
+
+	// Custom status set by the plugin
+	StoreCustomStatus(userID string, customStatus *CustomStatus) error
+	GetCustomStatus(userID string) (*CustomStatus, error)
+	DeleteCustomStatus(userID string) error
+
+	// Recommendations received by a user, newest first
+	StoreRecommendations(userID string, recommendations []*Recommendation) error
+	GetRecommendations(userID string) ([]*Recommendation, error)
+
+	// Listening history, stored in monthly buckets (YYYY-MM) with each bucket newest first
+	StoreHistory(userID, month string, entries []*HistoryEntry) error
+	GetHistory(userID, month string) ([]*HistoryEntry, error)
+	DeleteHistory(userID, month string) error
+	ListHistoryMonths(userID string) ([]string, error)
+
+	// Top artists and tracks caching
+	StoreTopList(userID, itemType, timeRange string, topList *TopList) error
+	GetTopList(userID, itemType, timeRange string) (*TopList, error)
+
 	// User data cleanup
 	ClearUserData(userID string) error
 }
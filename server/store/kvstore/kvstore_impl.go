@@ -2,11 +2,16 @@ package kvstore
 
 import (
 	"encoding/json"
+	"sort"
+	"strings"
 
 	"github.com/pkg/errors"
 	"golang.org/x/oauth2"
 )
 
+// topListCacheSeconds is how long top artists and tracks are cached, as Spotify only recalculates them occasionally
+const topListCacheSeconds = 6 * 60 * 60
+
 // Impl implements the KVStore interface for Spotify plugin data
 type Impl struct {
 	pluginAPI PluginAPI
@@ -98,6 +103,103 @@ func (kv *Impl) GetToken(userID string) (*oauth2.Token, error) {
 	return &token, nil
 }
 
+// DeleteToken removes the OAuth token for a user, leaving their email mapping in place so they can reconnect
+func (kv *Impl) DeleteToken(userID string) error {
+	err := kv.pluginAPI.KVDelete("token-" + userID)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete token")
+	}
+
+	return nil
+}
+
+// ListConnectedUserIDs returns the IDs of all users with a stored OAuth token
+func (kv *Impl) ListConnectedUserIDs() ([]string, error) {
+	keys, err := kv.pluginAPI.KVListKeys("token-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list tokens")
+	}
+
+	userIDs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		userIDs = append(userIDs, strings.TrimPrefix(key, "token-"))
+	}
+
+	return userIDs, nil
+}
+
+// StoreConnectionInfo stores the details of a user's Spotify connection
+func (kv *Impl) StoreConnectionInfo(userID string, info *ConnectionInfo) error {
+	if info == nil {
+		return errors.New("cannot store nil connection info")
+	}
+
+	infoJSON, err := json.Marshal(info)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal connection info")
+	}
+
+	err = kv.pluginAPI.KVSet("connection-"+userID, infoJSON)
+	if err != nil {
+		return errors.Wrap(err, "failed to store connection info")
+	}
+
+	return nil
+}
+
+// GetConnectionInfo retrieves the details of a user's Spotify connection, which are empty if none were recorded
+func (kv *Impl) GetConnectionInfo(userID string) (*ConnectionInfo, error) {
+	infoJSON, err := kv.pluginAPI.KVGet("connection-" + userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get connection info")
+	}
+
+	var info ConnectionInfo
+	if len(infoJSON) == 0 {
+		return &info, nil
+	}
+
+	if err := json.Unmarshal(infoJSON, &info); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal connection info")
+	}
+
+	return &info, nil
+}
+
+// StoreScopes stores the OAuth scopes the user granted when they last authorized
+func (kv *Impl) StoreScopes(userID string, scopes []string) error {
+	scopesJSON, err := json.Marshal(scopes)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal scopes")
+	}
+
+	err = kv.pluginAPI.KVSet("scopes-"+userID, scopesJSON)
+	if err != nil {
+		return errors.Wrap(err, "failed to store scopes")
+	}
+
+	return nil
+}
+
+// GetScopes retrieves the OAuth scopes granted by a user, or nil if they are unknown
+func (kv *Impl) GetScopes(userID string) ([]string, error) {
+	scopesJSON, err := kv.pluginAPI.KVGet("scopes-" + userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get scopes")
+	}
+
+	if len(scopesJSON) == 0 {
+		return nil, nil
+	}
+
+	var scopes []string
+	if err := json.Unmarshal(scopesJSON, &scopes); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal scopes")
+	}
+
+	return scopes, nil
+}
+
 // CacheStatus stores the Spotify player status for a user with configurable expiration
 func (kv *Impl) StoreCacheStatus(userID string, status *Status) error {
 	if status == nil {
@@ -147,6 +249,44 @@ func (kv *Impl) GetCachedStatus(userID string) (*Status, error) {
 	return &status, nil
 }
 
+// StoreLastPlayed stores the last status in which a user was playing
+func (kv *Impl) StoreLastPlayed(userID string, status *Status) error {
+	if status == nil {
+		return errors.New("cannot store nil last played status")
+	}
+
+	statusJSON, err := json.Marshal(status)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal last played status")
+	}
+
+	err = kv.pluginAPI.KVSet("last-played-"+userID, statusJSON)
+	if err != nil {
+		return errors.Wrap(err, "failed to store last played status")
+	}
+
+	return nil
+}
+
+// GetLastPlayed retrieves the last status in which a user was playing, or nil if they have never been seen playing
+func (kv *Impl) GetLastPlayed(userID string) (*Status, error) {
+	statusJSON, err := kv.pluginAPI.KVGet("last-played-" + userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get last played status")
+	}
+
+	if len(statusJSON) == 0 {
+		return nil, nil
+	}
+
+	var status Status
+	if err := json.Unmarshal(statusJSON, &status); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal last played status")
+	}
+
+	return &status, nil
+}
+
 // StoreContextName stores the name for a Spotify context (artist, playlist, album, show) indefinitely
 func (kv *Impl) StoreContextName(contextType, contextID, name string) error {
 	if name == "" {
@@ -175,7 +315,327 @@ func (kv *Impl) GetContextName(contextType, contextID string) (string, error) {
 	return string(nameBytes), nil
 }
 
-// ClearUserData removes all data associated with a user (mappings, token, and cached status)
+// StoreLinkPreview stores the preview for a linked Spotify item indefinitely
+func (kv *Impl) StoreLinkPreview(preview *LinkPreview) error {
+	if preview == nil {
+		return errors.New("cannot store nil link preview")
+	}
+
+	previewJSON, err := json.Marshal(preview)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal link preview")
+	}
+
+	err = kv.pluginAPI.KVSet("preview-"+preview.Type+"-"+preview.ID, previewJSON)
+	if err != nil {
+		return errors.Wrap(err, "failed to store link preview")
+	}
+
+	return nil
+}
+
+// GetLinkPreview retrieves the cached preview for a linked Spotify item, or nil if it is not cached
+func (kv *Impl) GetLinkPreview(linkType, linkID string) (*LinkPreview, error) {
+	previewJSON, err := kv.pluginAPI.KVGet("preview-" + linkType + "-" + linkID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get link preview")
+	}
+
+	if len(previewJSON) == 0 {
+		return nil, nil
+	}
+
+	var preview LinkPreview
+	if err := json.Unmarshal(previewJSON, &preview); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal link preview")
+	}
+
+	return &preview, nil
+}
+
+// StoreChannelPlaylist stores the playlist linked to a channel
+func (kv *Impl) StoreChannelPlaylist(channelPlaylist *ChannelPlaylist) error {
+	if channelPlaylist == nil {
+		return errors.New("cannot store nil channel playlist")
+	}
+
+	channelPlaylistJSON, err := json.Marshal(channelPlaylist)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal channel playlist")
+	}
+
+	err = kv.pluginAPI.KVSet("channel-playlist-"+channelPlaylist.ChannelID, channelPlaylistJSON)
+	if err != nil {
+		return errors.Wrap(err, "failed to store channel playlist")
+	}
+
+	return nil
+}
+
+// GetChannelPlaylist retrieves the playlist linked to a channel, or nil if none is linked
+func (kv *Impl) GetChannelPlaylist(channelID string) (*ChannelPlaylist, error) {
+	channelPlaylistJSON, err := kv.pluginAPI.KVGet("channel-playlist-" + channelID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get channel playlist")
+	}
+
+	if len(channelPlaylistJSON) == 0 {
+		return nil, nil
+	}
+
+	var channelPlaylist ChannelPlaylist
+	if err := json.Unmarshal(channelPlaylistJSON, &channelPlaylist); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal channel playlist")
+	}
+
+	return &channelPlaylist, nil
+}
+
+// DeleteChannelPlaylist unlinks the playlist from a channel
+func (kv *Impl) DeleteChannelPlaylist(channelID string) error {
+	err := kv.pluginAPI.KVDelete("channel-playlist-" + channelID)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete channel playlist")
+	}
+
+	return nil
+}
+
+// ListChannelPlaylistChannelIDs returns the IDs of all channels with a linked playlist
+func (kv *Impl) ListChannelPlaylistChannelIDs() ([]string, error) {
+	keys, err := kv.pluginAPI.KVListKeys("channel-playlist-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list channel playlists")
+	}
+
+	channelIDs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		channelIDs = append(channelIDs, strings.TrimPrefix(key, "channel-playlist-"))
+	}
+
+	return channelIDs, nil
+}
+
+// StoreUserSettings stores a user's plugin settings
+func (kv *Impl) StoreUserSettings(userID string, settings *UserSettings) error {
+	if settings == nil {
+		return errors.New("cannot store nil settings")
+	}
+
+	settingsJSON, err := json.Marshal(settings)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal settings")
+	}
+
+	err = kv.pluginAPI.KVSet("settings-"+userID, settingsJSON)
+	if err != nil {
+		return errors.Wrap(err, "failed to store settings")
+	}
+
+	return nil
+}
+
+// GetUserSettings retrieves a user's plugin settings, returning the defaults if they have never changed them
+func (kv *Impl) GetUserSettings(userID string) (*UserSettings, error) {
+	settingsJSON, err := kv.pluginAPI.KVGet("settings-" + userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get settings")
+	}
+
+	var settings UserSettings
+	if len(settingsJSON) == 0 {
+		return &settings, nil
+	}
+
+	if err := json.Unmarshal(settingsJSON, &settings); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal settings")
+	}
+
+	return &settings, nil
+}
+
+// StoreCustomStatus stores the custom status the plugin last set for a user
+func (kv *Impl) StoreCustomStatus(userID string, customStatus *CustomStatus) error {
+	if customStatus == nil {
+		return errors.New("cannot store nil custom status")
+	}
+
+	customStatusJSON, err := json.Marshal(customStatus)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal custom status")
+	}
+
+	err = kv.pluginAPI.KVSet("custom-status-"+userID, customStatusJSON)
+	if err != nil {
+		return errors.Wrap(err, "failed to store custom status")
+	}
+
+	return nil
+}
+
+// GetCustomStatus retrieves the custom status the plugin last set for a user, or nil if it hasn't set one
+func (kv *Impl) GetCustomStatus(userID string) (*CustomStatus, error) {
+	customStatusJSON, err := kv.pluginAPI.KVGet("custom-status-" + userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get custom status")
+	}
+
+	if len(customStatusJSON) == 0 {
+		return nil, nil
+	}
+
+	var customStatus CustomStatus
+	if err := json.Unmarshal(customStatusJSON, &customStatus); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal custom status")
+	}
+
+	return &customStatus, nil
+}
+
+// DeleteCustomStatus removes the record of the custom status the plugin set for a user
+func (kv *Impl) DeleteCustomStatus(userID string) error {
+	err := kv.pluginAPI.KVDelete("custom-status-" + userID)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete custom status")
+	}
+
+	return nil
+}
+
+// StoreRecommendations stores the recommendations received by a user
+func (kv *Impl) StoreRecommendations(userID string, recommendations []*Recommendation) error {
+	recommendationsJSON, err := json.Marshal(recommendations)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal recommendations")
+	}
+
+	err = kv.pluginAPI.KVSet("recommendations-"+userID, recommendationsJSON)
+	if err != nil {
+		return errors.Wrap(err, "failed to store recommendations")
+	}
+
+	return nil
+}
+
+// GetRecommendations retrieves the recommendations received by a user
+func (kv *Impl) GetRecommendations(userID string) ([]*Recommendation, error) {
+	recommendationsJSON, err := kv.pluginAPI.KVGet("recommendations-" + userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get recommendations")
+	}
+
+	if len(recommendationsJSON) == 0 {
+		return nil, nil
+	}
+
+	var recommendations []*Recommendation
+	if err := json.Unmarshal(recommendationsJSON, &recommendations); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal recommendations")
+	}
+
+	return recommendations, nil
+}
+
+// StoreHistory stores one month of a user's listening history
+func (kv *Impl) StoreHistory(userID, month string, entries []*HistoryEntry) error {
+	entriesJSON, err := json.Marshal(entries)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal history")
+	}
+
+	err = kv.pluginAPI.KVSet("history-"+userID+"-"+month, entriesJSON)
+	if err != nil {
+		return errors.Wrap(err, "failed to store history")
+	}
+
+	return nil
+}
+
+// GetHistory retrieves one month of a user's listening history
+func (kv *Impl) GetHistory(userID, month string) ([]*HistoryEntry, error) {
+	entriesJSON, err := kv.pluginAPI.KVGet("history-" + userID + "-" + month)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get history")
+	}
+
+	if len(entriesJSON) == 0 {
+		return nil, nil
+	}
+
+	var entries []*HistoryEntry
+	if err := json.Unmarshal(entriesJSON, &entries); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal history")
+	}
+
+	return entries, nil
+}
+
+// DeleteHistory removes one month of a user's listening history
+func (kv *Impl) DeleteHistory(userID, month string) error {
+	err := kv.pluginAPI.KVDelete("history-" + userID + "-" + month)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete history")
+	}
+
+	return nil
+}
+
+// ListHistoryMonths returns the months for which a user has listening history, newest first
+func (kv *Impl) ListHistoryMonths(userID string) ([]string, error) {
+	keys, err := kv.pluginAPI.KVListKeys("history-" + userID + "-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list history")
+	}
+
+	months := make([]string, 0, len(keys))
+	for _, key := range keys {
+		months = append(months, strings.TrimPrefix(key, "history-"+userID+"-"))
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(months)))
+
+	return months, nil
+}
+
+// StoreTopList caches a user's top artists or tracks for a time range
+func (kv *Impl) StoreTopList(userID, itemType, timeRange string, topList *TopList) error {
+	if topList == nil {
+		return errors.New("cannot store nil top list")
+	}
+
+	topListJSON, err := json.Marshal(topList)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal top list")
+	}
+
+	err = kv.pluginAPI.KVSet("top-"+userID+"-"+itemType+"-"+timeRange, topListJSON, topListCacheSeconds)
+	if err != nil {
+		return errors.Wrap(err, "failed to store top list")
+	}
+
+	return nil
+}
+
+// GetTopList retrieves a user's cached top artists or tracks for a time range, or nil if they are not cached
+func (kv *Impl) GetTopList(userID, itemType, timeRange string) (*TopList, error) {
+	topListJSON, err := kv.pluginAPI.KVGet("top-" + userID + "-" + itemType + "-" + timeRange)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get top list")
+	}
+
+	if len(topListJSON) == 0 {
+		return nil, nil
+	}
+
+	var topList TopList
+	if err := json.Unmarshal(topListJSON, &topList); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal top list")
+	}
+
+	return &topList, nil
+}
+
+// ClearUserData removes all data associated with a user (mappings, token, scopes, cached status, settings,
+// recommendations and history)
 func (kv *Impl) ClearUserData(userID string) error {
 	// Get the email first so we can delete both mappings
 	email, err := kv.GetEmailByUserID(userID)
@@ -186,11 +646,33 @@ func (kv *Impl) ClearUserData(userID string) error {
 	// Delete the user ID mapping
 	_ = kv.pluginAPI.KVDelete("uid-" + userID)
 
-	// Delete the OAuth token
+	// Delete the OAuth token, granted scopes and connection details
 	_ = kv.pluginAPI.KVDelete("token-" + userID)
+	_ = kv.pluginAPI.KVDelete("scopes-" + userID)
+	_ = kv.pluginAPI.KVDelete("connection-" + userID)
 
-	// Delete the cached status
+	// Delete the cached and last played statuses
 	_ = kv.pluginAPI.KVDelete("cached-status-" + userID)
+	_ = kv.pluginAPI.KVDelete("last-played-" + userID)
+
+	// Delete the record of the plugin's custom status
+	_ = kv.pluginAPI.KVDelete("custom-status-" + userID)
+
+	// Delete the user's settings and the recommendations they've received
+	_ = kv.pluginAPI.KVDelete("settings-" + userID)
+	_ = kv.pluginAPI.KVDelete("recommendations-" + userID)
+
+	// Delete the cached top artists and tracks
+	topKeys, _ := kv.pluginAPI.KVListKeys("top-" + userID + "-")
+	for _, key := range topKeys {
+		_ = kv.pluginAPI.KVDelete(key)
+	}
+
+	// Delete the listening history
+	months, _ := kv.ListHistoryMonths(userID)
+	for _, month := range months {
+		_ = kv.DeleteHistory(userID, month)
+	}
 
 	return nil
 }
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/clearstargroup/cs-mattermost-spotify-plugin/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/zmb3/spotify/v2"
+	spotifyauth "github.com/zmb3/spotify/v2/auth"
+)
+
+// topListLength is how many artists or tracks are shown by /spotify top
+const topListLength = 10
+
+// topRanges maps the time ranges accepted by /spotify top to Spotify's ranges and their descriptions
+var topRanges = map[string]struct {
+	spotifyRange spotify.Range
+	description  string
+	historyDays  int
+}{
+	"short":  {spotify.ShortTermRange, "last 4 weeks", 28},
+	"medium": {spotify.MediumTermRange, "last 6 months", 182},
+	"long":   {spotify.LongTermRange, "all time", 0},
+}
+
+// Command Plugin API - shows a user's top artists or tracks for a time range, from Spotify or, when the user
+// hasn't granted access to their top items, from their recorded listening history
+func (p *Plugin) Top(userID, itemType, timeRange string) (string, []*model.SlackAttachment) {
+	topRange, ok := topRanges[timeRange]
+	if !ok || (itemType != "artists" && itemType != "tracks") {
+		return fmt.Sprintf("Unknown top %s for %s.", itemType, timeRange), nil
+	}
+	title := fmt.Sprintf("#### Your top %s (%s)", itemType, topRange.description)
+
+	missing, err := p.getMissingScopes(userID, spotifyauth.ScopeUserTopRead)
+	if err != nil {
+		p.API.LogError("Failed to get missing scopes", "userID", userID, "error", err)
+		return "Failed to check Spotify permissions: " + err.Error(), nil
+	}
+	fromHistory := len(missing) > 0
+	reconnect := fmt.Sprintf("[Reconnect Spotify](%s) to see Spotify's own ranking.", p.reconnectURL(missing...))
+	historyTitle := title + "\nBased on the plays recorded by the plugin. " + reconnect
+
+	// A list calculated from history is only used until the user grants access to Spotify's own ranking
+	topList, err := p.kvstore.GetTopList(userID, itemType, timeRange)
+	if err != nil {
+		p.API.LogError("Failed to get cached top list", "userID", userID, "error", err)
+	}
+	if topList != nil && topList.FromHistory == fromHistory {
+		if fromHistory {
+			return historyTitle, topListAttachments(topList)
+		}
+		return title, topListAttachments(topList)
+	}
+
+	// Fall back to the recorded history when Spotify's own ranking isn't available
+	if fromHistory {
+		var since int64
+		if topRange.historyDays > 0 {
+			since = model.GetMillisForTime(time.Now().AddDate(0, 0, -topRange.historyDays))
+		}
+		history, err := p.getHistory(userID, since, 0)
+		if err != nil {
+			p.API.LogError("Failed to get history", "userID", userID, "error", err)
+			return "Failed to get history: " + err.Error(), nil
+		}
+		if len(history) == 0 {
+			return "This needs additional Spotify permissions. " + reconnect, nil
+		}
+
+		topList := topListFromHistory(history, itemType)
+		if err := p.kvstore.StoreTopList(userID, itemType, timeRange, topList); err != nil {
+			p.API.LogError("Failed to cache top list", "userID", userID, "error", err)
+		}
+		return historyTitle, topListAttachments(topList)
+	}
+
+	var attachments []*model.SlackAttachment
+	message := p.runAsUser(userID, []string{spotifyauth.ScopeUserTopRead}, func(ctx context.Context, client *spotify.Client) (string, error) {
+		topList, err := fetchTopList(ctx, client, itemType, topRange.spotifyRange)
+		if err != nil {
+			return "", err
+		}
+
+		if err := p.kvstore.StoreTopList(userID, itemType, timeRange, topList); err != nil {
+			p.API.LogError("Failed to cache top list", "userID", userID, "error", err)
+		}
+
+		if len(topList.Items) == 0 {
+			return fmt.Sprintf("Spotify doesn't have enough listening data to rank your top %s yet.", itemType), nil
+		}
+
+		attachments = topListAttachments(topList)
+		return title, nil
+	})
+
+	return message, attachments
+}
+
+// fetchTopList gets a user's top artists or tracks from Spotify
+func fetchTopList(ctx context.Context, client *spotify.Client, itemType string, spotifyRange spotify.Range) (*kvstore.TopList, error) {
+	topList := &kvstore.TopList{}
+	options := []spotify.RequestOption{spotify.Timerange(spotifyRange), spotify.Limit(topListLength)}
+
+	if itemType == "artists" {
+		artists, err := client.CurrentUsersTopArtists(ctx, options...)
+		if err != nil {
+			return nil, err
+		}
+		for _, artist := range artists.Artists {
+			topList.Items = append(topList.Items, kvstore.TopItem{
+				Name:     artist.Name,
+				Subtitle: strings.Join(artist.Genres, ", "),
+				URL:      artist.ExternalURLs["spotify"],
+				ImageURL: firstImageURL(artist.Images),
+			})
+		}
+		return topList, nil
+	}
+
+	tracks, err := client.CurrentUsersTopTracks(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	for _, track := range tracks.Tracks {
+		topList.Items = append(topList.Items, kvstore.TopItem{
+			Name:     track.Name,
+			Subtitle: joinArtistNames(track.Artists),
+			URL:      track.ExternalURLs["spotify"],
+			ImageURL: firstImageURL(track.Album.Images),
+		})
+	}
+	return topList, nil
+}
+
+// topListFromHistory ranks the artists or tracks in a user's history by how often they were played
+func topListFromHistory(history []*kvstore.HistoryEntry, itemType string) *kvstore.TopList {
+	itemsByKey := make(map[string]*kvstore.TopItem)
+	for _, entry := range history {
+		if itemType == "tracks" {
+			item, ok := itemsByKey[entry.TrackID]
+			if !ok {
+				item = &kvstore.TopItem{Name: entry.TrackName, Subtitle: entry.TrackArtists, URL: entry.TrackURL}
+				itemsByKey[entry.TrackID] = item
+			}
+			item.Plays++
+			continue
+		}
+
+		// Entries recorded before artists were stored separately only have the joined names, which are counted as
+		// one artist rather than split on commas that may be part of a name
+		artists := entry.ArtistNames
+		if len(artists) == 0 && entry.TrackArtists != "" {
+			artists = []string{entry.TrackArtists}
+		}
+		for _, artist := range artists {
+			item, ok := itemsByKey[artist]
+			if !ok {
+				item = &kvstore.TopItem{Name: artist}
+				itemsByKey[artist] = item
+			}
+			item.Plays++
+		}
+	}
+
+	topList := &kvstore.TopList{FromHistory: true}
+	for _, item := range itemsByKey {
+		topList.Items = append(topList.Items, *item)
+	}
+	sort.Slice(topList.Items, func(i, j int) bool {
+		if topList.Items[i].Plays != topList.Items[j].Plays {
+			return topList.Items[i].Plays > topList.Items[j].Plays
+		}
+		return topList.Items[i].Name < topList.Items[j].Name
+	})
+	if len(topList.Items) > topListLength {
+		topList.Items = topList.Items[:topListLength]
+	}
+
+	return topList
+}
+
+// topListAttachments builds a ranked message attachment for each item in a top list
+func topListAttachments(topList *kvstore.TopList) []*model.SlackAttachment {
+	attachments := make([]*model.SlackAttachment, 0, len(topList.Items))
+	for i, item := range topList.Items {
+		text := item.Subtitle
+		if topList.FromHistory {
+			plays := fmt.Sprintf("%d plays", item.Plays)
+			if item.Plays == 1 {
+				plays = "1 play"
+			}
+			text = strings.TrimSpace(text + "\n" + plays)
+		}
+
+		attachments = append(attachments, &model.SlackAttachment{
+			Color:     spotifyColor,
+			Title:     fmt.Sprintf("%d. %s", i+1, item.Name),
+			TitleLink: item.URL,
+			Text:      text,
+			ThumbURL:  item.ImageURL,
+		})
+	}
+	return attachments
+}
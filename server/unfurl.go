@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/clearstargroup/cs-mattermost-spotify-plugin/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	"github.com/zmb3/spotify/v2"
+)
+
+const (
+	// maxUnfurledLinks limits how many Spotify links in a single post get a preview
+	maxUnfurledLinks = 3
+	// unfurlTimeout limits how long fetching the previews for a post may take
+	unfurlTimeout = 10 * time.Second
+)
+
+// spotifyLinkPattern matches open.spotify.com links, capturing the item type and ID
+var spotifyLinkPattern = regexp.MustCompile(`https?://open\.spotify\.com/(?:intl-[a-zA-Z-]+/)?(track|album|playlist|artist|episode)/([A-Za-z0-9]+)`)
+
+// spotifyIDPattern matches a bare Spotify ID
+var spotifyIDPattern = regexp.MustCompile(`^[A-Za-z0-9]{22}$`)
+
+// spotifyLink identifies a Spotify item linked in a message
+type spotifyLink struct {
+	Type string
+	ID   string
+}
+
+// findSpotifyLinks returns the distinct Spotify items linked in a message, in order of appearance
+func findSpotifyLinks(message string) []spotifyLink {
+	var links []spotifyLink
+	seen := map[spotifyLink]bool{}
+	for _, match := range spotifyLinkPattern.FindAllStringSubmatch(message, -1) {
+		link := spotifyLink{Type: match[1], ID: match[2]}
+		if !seen[link] {
+			seen[link] = true
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// parseSpotifyID extracts the ID of a Spotify item of the given type from an open.spotify.com URL, a spotify: URI or a bare ID
+func parseSpotifyID(input, itemType string) (spotify.ID, bool) {
+	for _, link := range findSpotifyLinks(input) {
+		if link.Type == itemType {
+			return spotify.ID(link.ID), true
+		}
+	}
+
+	if parts := strings.Split(input, ":"); len(parts) == 3 && parts[0] == "spotify" && parts[1] == itemType && spotifyIDPattern.MatchString(parts[2]) {
+		return spotify.ID(parts[2]), true
+	}
+
+	if spotifyIDPattern.MatchString(input) {
+		return spotify.ID(input), true
+	}
+
+	return "", false
+}
+
+// unfurlSpotifyLinks attaches previews for the Spotify links in a post once it has been posted, so the post isn't held
+// up while they are fetched from Spotify
+func (p *Plugin) unfurlSpotifyLinks(post *model.Post) {
+	// Leave posts that already carry attachments, including the plugin's own posts, unchanged
+	if p.getAppClient() == nil || len(post.Attachments()) > 0 {
+		return
+	}
+
+	links := findSpotifyLinks(post.Message)
+	if len(links) == 0 {
+		return
+	}
+	if len(links) > maxUnfurledLinks {
+		links = links[:maxUnfurledLinks]
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), unfurlTimeout)
+	defer cancel()
+
+	var attachments []*model.SlackAttachment
+	for _, link := range links {
+		preview, err := p.getLinkPreview(ctx, link)
+		if err != nil {
+			p.API.LogError("Failed to get link preview", "type", link.Type, "id", link.ID, "error", err)
+			continue
+		}
+		attachments = append(attachments, linkPreviewAttachment(preview))
+	}
+
+	if len(attachments) == 0 {
+		return
+	}
+
+	post = post.Clone()
+	model.ParseSlackAttachment(post, attachments)
+	if err := p.client.Post.UpdatePost(post); err != nil {
+		p.API.LogError("Failed to attach link previews", "postID", post.Id, "error", err)
+	}
+}
+
+// getLinkPreview returns the preview for a linked Spotify item, using the cache where possible
+func (p *Plugin) getLinkPreview(ctx context.Context, link spotifyLink) (*kvstore.LinkPreview, error) {
+	// Try to get cached preview first
+	preview, err := p.kvstore.GetLinkPreview(link.Type, link.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get cached link preview")
+	}
+	if preview != nil {
+		return preview, nil
+	}
+
+	// Cache miss - fetch from Spotify API with the app's credentials
+	appClient := p.getAppClient()
+	if appClient == nil {
+		return nil, errors.New("Spotify not configured")
+	}
+	preview, err = p.fetchLinkPreview(ctx, appClient, link)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cache the fetched preview for future use
+	if err := p.kvstore.StoreLinkPreview(preview); err != nil {
+		p.API.LogError("Failed to cache link preview", "type", link.Type, "id", link.ID, "error", err)
+		// Don't return error - just log it and continue
+	}
+
+	p.API.LogInfo("Successfully fetched link preview", "type", link.Type, "id", link.ID, "name", preview.Name)
+
+	return preview, nil
+}
+
+// fetchLinkPreview fetches the details of a linked Spotify item from the Spotify API
+func (p *Plugin) fetchLinkPreview(ctx context.Context, client *spotify.Client, link spotifyLink) (*kvstore.LinkPreview, error) {
+	preview := &kvstore.LinkPreview{Type: link.Type, ID: link.ID}
+	id := spotify.ID(link.ID)
+
+	switch link.Type {
+	case "track":
+		track, err := client.GetTrack(ctx, id)
+		if err != nil || track == nil {
+			return nil, errors.Wrap(err, "failed to get track")
+		}
+		preview.Name = track.Name
+		preview.Subtitle = joinArtistNames(track.Artists)
+		preview.URL = track.ExternalURLs["spotify"]
+		preview.ImageURL = firstImageURL(track.Album.Images)
+		preview.DurationMs = int(track.Duration)
+	case "album":
+		album, err := client.GetAlbum(ctx, id)
+		if err != nil || album == nil {
+			return nil, errors.Wrap(err, "failed to get album")
+		}
+		preview.Name = album.Name
+		preview.Subtitle = joinArtistNames(album.Artists)
+		preview.URL = album.ExternalURLs["spotify"]
+		preview.ImageURL = firstImageURL(album.Images)
+		preview.TrackCount = int(album.Tracks.Total)
+
+		// Albums list their first page of tracks, so the rest are paged through for the total duration
+		tracks := &album.Tracks
+		for {
+			for _, track := range tracks.Tracks {
+				preview.DurationMs += int(track.Duration)
+			}
+			err := client.NextPage(ctx, tracks)
+			if errors.Is(err, spotify.ErrNoMorePages) {
+				break
+			}
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to get album tracks")
+			}
+		}
+	case "playlist":
+		playlist, err := client.GetPlaylist(ctx, id)
+		if err != nil || playlist == nil {
+			return nil, errors.Wrap(err, "failed to get playlist")
+		}
+		preview.Name = playlist.Name
+		preview.Subtitle = playlist.Owner.DisplayName
+		preview.URL = playlist.ExternalURLs["spotify"]
+		preview.ImageURL = firstImageURL(playlist.Images)
+		preview.TrackCount = int(playlist.Tracks.Total)
+	case "artist":
+		artist, err := client.GetArtist(ctx, id)
+		if err != nil || artist == nil {
+			return nil, errors.Wrap(err, "failed to get artist")
+		}
+		preview.Name = artist.Name
+		preview.URL = artist.ExternalURLs["spotify"]
+		preview.ImageURL = firstImageURL(artist.Images)
+	case "episode":
+		episode, err := client.GetEpisode(ctx, link.ID)
+		if err != nil || episode == nil {
+			return nil, errors.Wrap(err, "failed to get episode")
+		}
+		preview.Name = episode.Name
+		preview.Subtitle = episode.Show.Name
+		preview.URL = episode.ExternalURLs["spotify"]
+		preview.ImageURL = firstImageURL(episode.Images)
+		preview.DurationMs = int(episode.Duration_ms)
+	default:
+		return nil, errors.Errorf("unsupported link type %s", link.Type)
+	}
+
+	return preview, nil
+}
+
+// linkPreviewAttachment builds a message attachment previewing a linked Spotify item
+func linkPreviewAttachment(preview *kvstore.LinkPreview) *model.SlackAttachment {
+	attachment := &model.SlackAttachment{
+		Fallback:   fmt.Sprintf("%s on Spotify: %s", preview.Name, preview.URL),
+		Color:      spotifyColor,
+		AuthorName: "Spotify " + preview.Type,
+		AuthorLink: "https://open.spotify.com",
+		Title:      preview.Name,
+		TitleLink:  preview.URL,
+		Text:       preview.Subtitle,
+		ThumbURL:   preview.ImageURL,
+	}
+
+	if preview.TrackCount > 0 {
+		attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+			Title: "Tracks",
+			Value: fmt.Sprintf("%d", preview.TrackCount),
+			Short: true,
+		})
+	}
+	if preview.DurationMs > 0 {
+		attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+			Title: "Duration",
+			Value: formatDuration(preview.DurationMs),
+			Short: true,
+		})
+	}
+
+	if preview.Type == "track" {
+		attachment.Actions = trackActions(preview.ID, preview.Name, preview.URL)
+	}
+
+	return attachment
+}
+
+// firstImageURL returns the URL of the first (widest) image, or an empty string if there are none
+func firstImageURL(images []spotify.Image) string {
+	if len(images) == 0 {
+		return ""
+	}
+	return images[0].URL
+}
+
+// formatDuration formats a duration in milliseconds as m:ss, or h:mm:ss when over an hour
+func formatDuration(ms int) string {
+	seconds := ms / 1000
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
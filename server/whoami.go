@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/clearstargroup/cs-mattermost-spotify-plugin/server/store/kvstore"
+)
+
+// updateConnectionInfo applies a change to the recorded details of a user's Spotify connection. Statuses are fetched
+// concurrently, so the change is made under the user's lock to avoid losing another fetch's update.
+func (p *Plugin) updateConnectionInfo(userID string, update func(info *kvstore.ConnectionInfo)) {
+	unlock, err := p.lock("connection-lock-" + userID)
+	if err != nil {
+		p.API.LogError("Failed to lock connection info", "userID", userID, "error", err)
+		return
+	}
+	defer unlock()
+
+	info, err := p.kvstore.GetConnectionInfo(userID)
+	if err != nil {
+		p.API.LogError("Failed to get connection info", "userID", userID, "error", err)
+		return
+	}
+
+	update(info)
+
+	if err := p.kvstore.StoreConnectionInfo(userID, info); err != nil {
+		p.API.LogError("Failed to store connection info", "userID", userID, "error", err)
+	}
+}
+
+// Command Plugin API - describes a user's Spotify connection, to help diagnose why their status isn't showing
+func (p *Plugin) Whoami(userID string) (string, error) {
+	email, err := p.kvstore.GetEmailByUserID(userID)
+	if err != nil {
+		return "Spotify is not enabled, run `/spotify enable your@spotifyemail.com` first.", nil
+	}
+
+	info, err := p.kvstore.GetConnectionInfo(userID)
+	if err != nil {
+		return "", err
+	}
+	token, err := p.kvstore.GetToken(userID)
+	if err != nil {
+		p.API.LogError("Failed to get token", "userID", userID, "error", err)
+	}
+	scopes, err := p.getGrantedScopes(userID)
+	if err != nil {
+		return "", err
+	}
+	settings, err := p.kvstore.GetUserSettings(userID)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	var sb strings.Builder
+	sb.WriteString("#### Your Spotify connection\n")
+	sb.WriteString("| | |\n|:--|:--|\n")
+
+	account := email
+	if info.SpotifyDisplayName != "" {
+		account = fmt.Sprintf("%s (%s)", info.SpotifyDisplayName, email)
+	}
+	fmt.Fprintf(&sb, "| Spotify account | %s |\n", account)
+
+	switch {
+	case token == nil:
+		fmt.Fprintf(&sb, "| Connected | No, [connect Spotify](%s) |\n", p.reconnectURL())
+	case info.ConnectedAt != 0:
+		fmt.Fprintf(&sb, "| Connected | Yes, %s |\n", formatTimeAgo(time.UnixMilli(info.ConnectedAt), now))
+	default:
+		sb.WriteString("| Connected | Yes |\n")
+	}
+
+	if token != nil {
+		if until := time.Until(token.Expiry); until > 0 {
+			fmt.Fprintf(&sb, "| Access token | Expires in %d minutes, then refreshed automatically |\n", int(until.Minutes()))
+		} else {
+			sb.WriteString("| Access token | Expired, refreshed on next use |\n")
+		}
+	}
+
+	fmt.Fprintf(&sb, "| Granted scopes | `%s` |\n", strings.Join(scopes, "`, `"))
+
+	if info.LastFetchAt != 0 {
+		fmt.Fprintf(&sb, "| Last successful fetch | %s |\n", formatTimeAgo(time.UnixMilli(info.LastFetchAt), now))
+	} else {
+		sb.WriteString("| Last successful fetch | Never |\n")
+	}
+
+	if info.LastError != "" {
+		fmt.Fprintf(&sb, "| Last error | %s, %s |\n", strings.ReplaceAll(info.LastError, "|", "\\|"), formatTimeAgo(time.UnixMilli(info.LastErrorAt), now))
+	} else {
+		sb.WriteString("| Last error | None |\n")
+	}
+
+	visibility := settings.Visibility
+	if visibility == "" {
+		visibility = visibilityEveryone
+	}
+	fmt.Fprintf(&sb, "| Visible to | %s |\n", visibility)
+
+	recommendations := "Accepted"
+	if settings.RefuseRecommendations {
+		recommendations = "Refused"
+	}
+	fmt.Fprintf(&sb, "| Recommendations | %s |\n", recommendations)
+
+	return sb.String(), nil
+}
@@ -0,0 +1,79 @@
+import * as React from 'react';
+
+import {Client4} from 'mattermost-redux/client';
+
+import manifest from './manifest';
+
+type ConnectionCheck = {
+    Name: string;
+    OK: boolean;
+    Message: string;
+};
+
+type ConnectionTestResult = {
+    OK: boolean;
+    Checks: ConnectionCheck[];
+};
+
+type Props = {
+    helpText?: React.ReactNode;
+    disabled?: boolean;
+};
+
+type State = {
+    testing: boolean;
+    result: ConnectionTestResult | null;
+    error: string | null;
+};
+
+// Admin console setting that tests the saved Spotify credentials and redirect URL
+export default class ConnectionTest extends React.PureComponent<Props, State> {
+    constructor(props: Props) {
+        super(props);
+        this.state = {testing: false, result: null, error: null};
+    }
+
+    testConnection = async () => {
+        this.setState({testing: true, result: null, error: null});
+        try {
+            const response = await fetch(Client4.getUrl() + '/plugins/' + manifest.id + '/api/v1/admin/connection-test', Client4.getOptions({method: 'post'}));
+            if (!response.ok) {
+                throw new Error(await response.text());
+            }
+            this.setState({result: await response.json() as ConnectionTestResult});
+        } catch (err) {
+            this.setState({error: (err as Error).message});
+        } finally {
+            this.setState({testing: false});
+        }
+    };
+
+    render() {
+        return (
+            <div>
+                <button
+                    className='btn btn-tertiary'
+                    disabled={this.props.disabled || this.state.testing}
+                    onClick={this.testConnection}
+                >
+                    {this.state.testing ? 'Testing...' : 'Test Connection'}
+                </button>
+                {this.state.error && (
+                    <div className='alert alert-danger'>{'Failed to test the connection: ' + this.state.error}</div>
+                )}
+                {this.state.result && (
+                    <ul style={{listStyle: 'none', paddingLeft: 0, marginTop: 12}}>
+                        {this.state.result.Checks.map((check) => (
+                            <li key={check.Name}>
+                                {check.OK ? '✅ ' : '❌ '}
+                                <strong>{check.Name + ': '}</strong>
+                                {check.Message}
+                            </li>
+                        ))}
+                    </ul>
+                )}
+                {this.props.helpText && <div className='help-text'>{this.props.helpText}</div>}
+            </div>
+        );
+    }
+}
@@ -3,7 +3,7 @@ import {connect} from 'react-redux';
 
 import type {GlobalState} from '@mattermost/types/store';
 
-import {getUserStatus, type PlayerStatus} from './index';
+import {formatTimeAgo, getUserStatus, type PlayerStatus} from './index';
 
 type Props = {
     state?: GlobalState;
@@ -28,9 +28,21 @@ class SpotifyInfo extends React.PureComponent<Props, {status: PlayerStatus | nul
     }
 
     render() {
+        // The user's visibility settings hide their listening
+        if (this.state.status && this.state.status.IsHidden) {
+            return null;
+        }
         if (!this.state.status || !this.state.status.IsConnected) {
             return (<span>{'Spotify: Not connected'}</span>);
         }
+        if (!this.state.status.IsPlaying && this.state.status.LastPlayedAt && this.state.status.TrackName) {
+            return (<>
+                <span>{'Spotify: Last listened to'}</span>
+                <br/>
+                {/* eslint-disable-next-line @mattermost/use-external-link, react/jsx-max-props-per-line */}
+                <span><a href={this.state.status.TrackURL} target='_blank' rel='noopener noreferrer'>{this.state.status.TrackName}</a>{', '}{formatTimeAgo(this.state.status.LastPlayedAt)}</span>
+            </>);
+        }
         if (!this.state.status.IsPlaying) {
             return (<span>{'Spotify: Not playing'}</span>);
         }
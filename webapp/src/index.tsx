@@ -7,19 +7,45 @@ import type {GlobalState} from '@mattermost/types/store';
 
 import {getConfig} from 'mattermost-redux/selectors/entities/general';
 
+import ConnectionTest from './ConnectionTest';
 import manifest from './manifest';
 import StatusComponent from './StatusComponent';
 import type {PluginRegistry} from './types/mattermost-webapp';
 import UserMusicIndicator from './UserMusicIndicator';
 
 export type PlayerStatus = {
+    IsHidden?: boolean;
     IsConnected: boolean;
     IsPlaying: boolean;
     PlaybackType: string;
     PlaybackURL: string;
     PlaybackName: string;
+    TrackID?: string;
+    TrackName?: string;
+    TrackArtists?: string;
+    TrackURL?: string;
+    AlbumName?: string;
+    ImageURL?: string;
+    LastPlayedAt?: number;
 };
 
+// Describes how long ago a time in milliseconds since the epoch was, e.g. "25 minutes ago"
+export function formatTimeAgo(time: number): string {
+    const minutes = Math.floor((Date.now() - time) / 60000);
+    const plural = (n: number, unit: string) => (n === 1 ? `1 ${unit} ago` : `${n} ${unit}s ago`);
+
+    if (minutes < 1) {
+        return 'just now';
+    }
+    if (minutes < 60) {
+        return plural(minutes, 'minute');
+    }
+    if (minutes < 24 * 60) {
+        return plural(Math.floor(minutes / 60), 'hour');
+    }
+    return plural(Math.floor(minutes / (24 * 60)), 'day');
+}
+
 export const getPluginServerRoute = (state: GlobalState) => {
     // eslint-disable-next-line @typescript-eslint/no-explicit-any
     const config = getConfig(state as any);
@@ -49,6 +75,9 @@ export default class Plugin {
 
         // Register the component that shows music icons next to usernames
         registry.registerGlobalComponent(UserMusicIndicator);
+
+        // Register the admin console button that tests the Spotify credentials
+        registry.registerAdminConsoleCustomSetting('ConnectionTest', ConnectionTest);
     }
 }
 